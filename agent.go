@@ -0,0 +1,535 @@
+package main
+
+import (
+        "io"
+        "os"
+        "fmt"
+        "net"
+        "flag"
+        "sync"
+        "time"
+        "bufio"
+        "errors"
+        "strconv"
+        "strings"
+        "syscall"
+        "os/exec"
+        "os/signal"
+        "io/ioutil"
+        "path/filepath"
+        "encoding/json"
+        "encoding/base64"
+        "golang.org/x/crypto/ssh/terminal"
+        sshagent "golang.org/x/crypto/ssh/agent"
+)
+
+//pass-agent keeps unlocked database in memory, so short-lived pass commands
+//and scripts get secrets without asking for pass phrase every time.
+//It listens on Unix socket in directory accessible by the owner only,
+//every request and response is a single line of JSON.
+//Agent exits and forgets the key after timeout without requests
+//agentTimeout is default of -timeout, see config.go
+var agentTimeout = 15 * time.Minute
+const agentSockEnv = "PASS_AGENT_SOCK"
+
+type agentRequest struct {
+        Cmd   string   `json:"cmd"`
+        Name  string   `json:"name,omitempty"`
+        Field string   `json:"field,omitempty"` //empty for password
+        Login string   `json:"login,omitempty"` //login, urls and value are used by store and erase
+        Urls  []string `json:"urls,omitempty"`
+        Value string   `json:"value,omitempty"`
+}
+
+type agentEntry struct {
+        Id    string   `json:"id"`
+        Path  string   `json:"path"`
+        Login string   `json:"login,omitempty"`
+        Urls  []string `json:"urls,omitempty"`
+}
+
+type agentResponse struct {
+        Ok      bool         `json:"ok"`
+        Error   string       `json:"error,omitempty"`
+        Code    int          `json:"code,omitempty"` //exit code for the error
+        Value   string       `json:"value,omitempty"`
+        Count   int          `json:"count,omitempty"`
+        Records []agentEntry `json:"records,omitempty"`
+}
+
+type agent struct {
+        sync.Mutex
+        d     *Database
+        ln    net.Listener
+        timer *time.Timer
+        mtime time.Time //database file modification time at last load
+        ttl   time.Duration
+        keys  sshagent.ExtendedAgent //ssh keys of the vault, nil unless -ssh is given
+        sshln net.Listener
+        clip  *time.Timer //clears clipboard after paste
+}
+
+//agentSocket returns socket path, which can be overridden by environment,
+//per user runtime directory is preferred over shared temp directory
+func agentSocket() string {
+        if s := os.Getenv(agentSockEnv); s != "" {
+                return s
+        }
+        if d := os.Getenv("XDG_RUNTIME_DIR"); d != "" {
+                return filepath.Join(d, "pass", "agent.sock")
+        }
+        u := strconv.Itoa(os.Getuid())
+        if os.Getuid() < 0 {
+                u = os.Getenv("USERNAME")
+        }
+        return filepath.Join(os.TempDir(), "pass-" + u, "agent.sock")
+}
+
+//agentDir creates socket directory and makes sure nobody else can access it
+func agentDir(sock string) error {
+        dir := filepath.Dir(sock)
+        if err := os.MkdirAll(dir, 0700); err != nil {
+                return err
+        }
+        fi, err := os.Lstat(dir)
+        if err != nil {
+                return err
+        }
+        //Windows has no permission bits, access is checked by ACL there
+        if fi.IsDir() && fi.Mode().Perm() & 0077 != 0 && os.Getuid() >= 0 && ownedByUs(fi) {
+                if err = os.Chmod(dir, 0700); err != nil {
+                        return fmt.Errorf("%s is accessible by others: %s", dir, err)
+                }
+        }
+        return checkAgentDir(dir)
+}
+
+//checkAgentDir makes sure socket directory wasn't created by another user,
+//who could run fake agent there and collect secrets sent to it
+func checkAgentDir(dir string) error {
+        fi, err := os.Lstat(dir)
+        if err != nil {
+                return err
+        }
+        if !fi.IsDir() {
+                return fmt.Errorf("%s is not a directory", dir)
+        }
+        if os.Getuid() < 0 {
+                return nil
+        }
+        if !ownedByUs(fi) {
+                return fmt.Errorf("%s is owned by another user", dir)
+        }
+        if fi.Mode().Perm() != 0700 {
+                return fmt.Errorf("%s is accessible by others", dir)
+        }
+        return nil
+}
+
+//passAgent is "pass agent [-timeout duration] [-ssh] [-foreground] file"
+func passAgent(args []string) int {
+        fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+        ttl := fs.Duration("timeout", agentTimeout, "forget pass phrase after this idle time")
+        fg := fs.Bool("foreground", false, "don't detach from terminal")
+        ssh := fs.Bool("ssh", false, "serve ssh keys of the vault over ssh-agent protocol")
+        if err := fs.Parse(args); err != nil {
+                return exitUsage
+        }
+        fn := vaultFile
+        if fs.NArg() == 1 {
+                fn = fs.Arg(0)
+        }
+        if fs.NArg() > 1 || fn == "" {
+                fmt.Fprintln(os.Stderr, "Usage: pass agent [-timeout 15m] [-ssh] [-foreground] file")
+                return exitUsage
+        }
+
+        sock := agentSocket()
+        if _, err := agentCall(agentRequest{Cmd: "status"}); err == nil {
+                fmt.Fprintf(os.Stderr, "Agent is already running on %s\n", sock)
+                return exitError
+        }
+
+        var p []byte
+        if terminal.IsTerminal(int(syscall.Stdin)) {
+                var err error
+                if p, err = readPassphrase(); err != nil {
+                        return exitError
+                }
+        } else {
+                //detached agent gets pass phrase from its parent through stdin
+                l, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+                p = []byte(strings.TrimRight(l, "\r\n"))
+        }
+        d, err := loadVault(fn, p)
+        if err != nil {
+                return cliError(err)
+        }
+
+        if !*fg {
+                return agentDetach(d.filename, p, *ttl, *ssh)
+        }
+
+        if err = agentDir(sock); err != nil {
+                return cliError(err)
+        }
+        //socket left by crashed agent
+        os.Remove(sock)
+        ln, err := net.Listen("unix", sock)
+        if err != nil {
+                return cliError(err)
+        }
+        os.Chmod(sock, 0600)
+
+        a := &agent{d: d, ln: ln, ttl: *ttl}
+        if fi, err := os.Stat(d.filename); err == nil {
+                a.mtime = fi.ModTime()
+        }
+        a.timer = time.AfterFunc(a.ttl, a.stop)
+        if *ssh {
+                a.keys = sshKeys(d)
+                if err = a.serveSSH(); err != nil {
+                        fmt.Fprintf(os.Stderr, "Error %s\n", err)
+                        a.stop()
+                        return exitError
+                }
+                fmt.Printf("%s=%s\n", sshSockEnv, sshSocket())
+        }
+
+        sig := make(chan os.Signal, 1)
+        signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+        signal.Ignore(syscall.SIGHUP)
+        go func() {
+                <-sig
+                a.stop()
+        }()
+
+        fmt.Printf("%s=%s\n", agentSockEnv, sock)
+        a.serve()
+        return exitOK
+}
+
+//agentDetach starts agent again in background and hands pass phrase to it
+func agentDetach(fn string, p []byte, ttl time.Duration, ssh bool) int {
+        exe, err := os.Executable()
+        if err != nil {
+                return cliError(err)
+        }
+        //settings given on command line are not in environment of the agent
+        args := append(append([]string(nil), configArgs...), "agent", "-foreground", "-timeout", ttl.String())
+        if ssh {
+                args = append(args, "-ssh")
+        }
+        c := exec.Command(exe, append(args, fn)...)
+        in, err := c.StdinPipe()
+        if err != nil {
+                return cliError(err)
+        }
+        if err = c.Start(); err != nil {
+                return cliError(err)
+        }
+        in.Write(append(p, '\n'))
+        in.Close()
+
+        //wait until socket is up
+        for i := 0; i < 50; i++ {
+                if _, err = agentCall(agentRequest{Cmd: "status"}); err == nil {
+                        fmt.Printf("Agent started, pid %d, idle timeout %s\n", c.Process.Pid, ttl)
+                        fmt.Printf("%s=%s\n", agentSockEnv, agentSocket())
+                        if ssh {
+                                fmt.Printf("%s=%s\n", sshSockEnv, sshSocket())
+                        }
+                        c.Process.Release()
+                        return exitOK
+                }
+                time.Sleep(100 * time.Millisecond)
+        }
+        fmt.Fprintln(os.Stderr, "Error agent didn't start")
+        c.Process.Kill()
+        return exitError
+}
+
+func (a *agent) serve() {
+        for {
+                c, err := a.ln.Accept()
+                if err != nil {
+                        return
+                }
+                go a.handle(c)
+        }
+}
+
+//stop wipes the key and shuts agent down
+func (a *agent) stop() {
+        a.Lock()
+        defer a.Unlock()
+        if a.d == nil {
+                return
+        }
+        a.d.key.wipe()
+        for _, k := range a.d.others {
+                k.wipe()
+        }
+        a.d = nil
+        //agent exits right after, pending clear wouldn't run
+        if a.clip != nil && a.clip.Stop() {
+                clearClipboard()
+        }
+        if a.keys != nil {
+                a.keys.RemoveAll()
+                a.keys = nil
+        }
+        if a.sshln != nil {
+                a.sshln.Close()
+                os.Remove(sshSocket())
+        }
+        //serve returns and process exits once listener is closed
+        os.Remove(agentSocket())
+        a.ln.Close()
+}
+
+func (a *agent) handle(c net.Conn) {
+        defer c.Close()
+        r := bufio.NewReader(c)
+        enc := json.NewEncoder(c)
+        for {
+                l, err := r.ReadBytes('\n')
+                if err != nil {
+                        return
+                }
+                var req agentRequest
+                var resp agentResponse
+                if err = json.Unmarshal(l, &req); err != nil {
+                        resp.Error = "Invalid request"
+                } else {
+                        resp = a.do(req)
+                }
+                if enc.Encode(resp) != nil || req.Cmd == "lock" {
+                        return
+                }
+        }
+}
+
+func (a *agent) do(req agentRequest) agentResponse {
+        if req.Cmd == "lock" {
+                go a.stop()
+                return agentResponse{Ok: true}
+        }
+
+        a.Lock()
+        defer a.Unlock()
+        if a.d == nil {
+                return agentResponse{Error: "Agent is locked", Code: exitLocked}
+        }
+        //polling status or list doesn't keep agent unlocked
+        switch req.Cmd {
+        case "get", "paste", "store", "erase":
+                a.timer.Reset(a.ttl)
+        }
+        a.reload()
+
+        s := &localSecrets{a.d}
+        var resp agentResponse
+        var err error
+        switch req.Cmd {
+        case "status":
+                resp.Value, resp.Count = a.d.filename, len(a.d.records)
+        case "list":
+                resp.Records, err = s.list(req.Name)
+        case "get", "paste":
+                var p []byte
+                p, err = s.get(req.Name, req.Field)
+                if err != nil || req.Cmd == "get" {
+                        resp.Value = string(p)
+                        break
+                }
+                if err = writeClipboard(string(p)); err != nil {
+                        err = errors.New("Error pasting into clipboard")
+                        break
+                }
+                if a.clip != nil {
+                        a.clip.Stop()
+                }
+                a.clip = time.AfterFunc(clipboardTimeout, func() { clearClipboard() })
+        case "store":
+                err = s.store(agentEntry{Path: req.Name, Login: req.Login, Urls: req.Urls}, []byte(req.Value))
+        case "erase":
+                err = s.erase(req.Name, []byte(req.Value))
+        default:
+                err = errors.New("Unknown command " + req.Cmd)
+        }
+        if err != nil {
+                return agentResponse{Error: err.Error(), Code: exitCode(err)}
+        }
+        //own changes don't need reload
+        if fi, err := os.Stat(a.d.filename); err == nil {
+                a.mtime = fi.ModTime()
+        }
+        resp.Ok = true
+        return resp
+}
+
+//reload picks up database saved by another pass process since last request
+func (a *agent) reload() {
+        fi, err := os.Stat(a.d.filename)
+        if err != nil || fi.ModTime().Equal(a.mtime) {
+                return
+        }
+        data, err := ioutil.ReadFile(a.d.filename)
+        if err != nil {
+                return
+        }
+        //database may be saved with another pass phrase, keep what we have then
+        records, sha, err := decodeVault(data, a.d.keyOf(data))
+        if err != nil {
+                return
+        }
+        assignIDs(records)
+        a.d.records, a.d.sha, a.mtime = records, sha, fi.ModTime()
+        if a.keys != nil {
+                a.keys.RemoveAll()
+                a.keys = sshKeys(a.d)
+        }
+}
+
+//secret returns value requested from the agent: password when field is empty,
+//login, current time-based otp code or custom field
+func (v *Record) secret(field string) ([]byte, error) {
+        switch field {
+        case "":
+                p, err := base64.StdEncoding.DecodeString(v.pass)
+                if err == nil && len(p) == 0 {
+                        err = notFoundf("No password stored in %s", v.path())
+                }
+                return p, err
+        case "login":
+                return []byte(v.login), nil
+        case "otp":
+                //counter-based codes change database, agent never writes it
+                if o, err := parseOTP(v.otp); err == nil && o.kind == "hotp" {
+                        return nil, fmt.Errorf("Counter-based otp of %s is available in pass only", v.path())
+                }
+                c, _, err := v.otpCode()
+                return []byte(c), err
+        }
+        return v.fieldValue(field)
+}
+
+//agentCall sends single request to running agent
+func agentCall(req agentRequest) (agentResponse, error) {
+        var resp agentResponse
+        if err := checkAgentDir(filepath.Dir(agentSocket())); err != nil {
+                if os.IsNotExist(err) {
+                        return resp, lockedf("Agent is not running")
+                }
+                return resp, fmt.Errorf("Agent socket is not safe: %s", err)
+        }
+        c, err := net.DialTimeout("unix", agentSocket(), time.Second)
+        if err != nil {
+                return resp, lockedf("Agent is not running")
+        }
+        defer c.Close()
+        if err = json.NewEncoder(c).Encode(req); err != nil {
+                return resp, err
+        }
+        if err = json.NewDecoder(c).Decode(&resp); err != nil && err != io.EOF {
+                return resp, err
+        }
+        if !resp.Ok {
+                return resp, &codeError{resp.Code, resp.Error}
+        }
+        return resp, nil
+}
+
+//agentClient runs command line request: get, paste and list are served
+//by the agent or by unlocked database, status and lock need the agent.
+//--json or --format json prints result as JSON
+func agentClient(cmd string, args []string) int {
+        format, args, err := cliFormat(args)
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "Error %s\n", err)
+                return exitUsage
+        }
+        req := agentRequest{Cmd: cmd}
+        switch {
+        case cmd == "get" || cmd == "paste":
+                if len(args) < 1 || len(args) > 2 {
+                        fmt.Fprintf(os.Stderr, "Usage: pass %s [--json] name [field|login|otp]\n", cmd)
+                        return exitUsage
+                }
+                req.Name = args[0]
+                if len(args) == 2 {
+                        req.Field = args[1]
+                }
+        case cmd == "list" && len(args) <= 1:
+                if len(args) == 1 {
+                        req.Name = args[0]
+                }
+        case len(args) != 0:
+                fmt.Fprintf(os.Stderr, "Usage: pass %s [--json]\n", cmd)
+                return exitUsage
+        }
+
+        if cmd == "status" || cmd == "lock" {
+                resp, err := agentCall(req)
+                if err != nil {
+                        return cliError(err)
+                }
+                switch {
+                case cmd == "lock" && format == "text":
+                        fmt.Println("Agent stopped")
+                case cmd == "status" && format == "text":
+                        fmt.Printf("%s, %d records\n", resp.Value, resp.Count)
+                case cmd == "status":
+                        printJSON(map[string]interface{}{"file": resp.Value, "records": resp.Count})
+                }
+                return exitOK
+        }
+
+        s, err := openSecrets()
+        if err != nil {
+                return cliError(err)
+        }
+        switch cmd {
+        case "get":
+                var p []byte
+                if p, err = s.get(req.Name, req.Field); err == nil {
+                        if format == "json" {
+                                printJSON(map[string]string{"name": req.Name, "field": req.Field, "value": string(p)})
+                        } else {
+                                fmt.Println(string(p))
+                        }
+                }
+        case "list":
+                var l []agentEntry
+                if l, err = s.list(req.Name); err == nil {
+                        if format == "json" {
+                                if l == nil {
+                                        l = []agentEntry{}
+                                }
+                                printJSON(l)
+                        }
+                        for _, e := range l {
+                                if format == "text" {
+                                        fmt.Printf("%s\tlogin: %s\n", e.Path, e.Login)
+                                }
+                        }
+                }
+        case "paste":
+                //agent clears clipboard itself, otherwise wait here
+                if _, ok := s.(agentSecrets); ok {
+                        if _, err = agentCall(req); err == nil {
+                                fmt.Printf("Copied to clipboard, clears in %s\n", clipboardTimeout)
+                        }
+                } else {
+                        var p []byte
+                        if p, err = s.get(req.Name, req.Field); err == nil {
+                                pasteSecret("Secret", p)
+                        }
+                }
+        }
+        if err != nil {
+                return cliError(err)
+        }
+        return exitOK
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+        "fmt"
+        "time"
+        "sort"
+        "bufio"
+        "strconv"
+)
+
+const defaultMaxAge = 180 //days
+
+func passAging(r *bufio.Reader, a *Args) {
+        if len(db.records) == 0 && !jsonOutput() {
+                fmt.Println("No records found")
+                return
+        }
+
+        s := a.opt(r, fmt.Sprintf("Max age in days [%d]", defaultMaxAge))
+        days := defaultMaxAge
+        if s != "" {
+                d, err := strconv.Atoi(s)
+                if err != nil || d < 0 {
+                        fmt.Printf("Invalid number of days: %s\n", s)
+                        return
+                }
+                days = d
+        }
+
+        old := agedRecords(time.Duration(days) * 24 * time.Hour)
+        if jsonOutput() {
+                printJSON(jsonRecords(old))
+                return
+        }
+        if len(old) == 0 {
+                fmt.Printf("No passwords older than %d days\n", days)
+                return
+        }
+        for _, v := range old {
+                fmt.Printf("[%s]:\tlogin: %s\tchanged: %s\tage: %s\n", v.path(), v.login, formatTime(v.changed), v.passAge())
+        }
+        fmt.Printf("%d of %d passwords are older than %d days\n", len(old), len(db.records), days)
+}
+
+//agedRecords returns records with password older than max age, oldest first
+//records with unknown change time are treated as the oldest
+func agedRecords(max time.Duration) []Record {
+        var out []Record
+        for _, v := range db.records {
+                if v.changed.IsZero() || time.Since(v.changed) > max {
+                        out = append(out, v)
+                }
+        }
+        sort.SliceStable(out, func(i, j int) bool {
+                return out[i].changed.Before(out[j].changed)
+        })
+        return out
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+        "os"
+        "fmt"
+        "net"
+        "sort"
+        "sync"
+        "time"
+        "bufio"
+        "strconv"
+        "strings"
+        "net/url"
+        "net/http"
+        "io/ioutil"
+        "crypto/rand"
+        "crypto/sha256"
+        "encoding/hex"
+        "encoding/json"
+        "path/filepath"
+        "crypto/subtle"
+)
+
+//HTTP API lets browser extensions and other local tools query active vault
+//while pass shell is running. Server listens on loopback only, every client
+//pairs first: POST /v1/pair {"client": "name"} waits until user approves it
+//in the shell and returns token, which is sent as "Authorization: Bearer token":
+//  GET /v1/search?q=pattern          records matching pattern, no secrets
+//  GET /v1/login?url=...[&user=...]  best matching record for url
+//  GET /v1/password?url=...[&user=...] same with password
+//Hashes of tokens are kept in clients file, so pairing survives restart
+const defaultAPIPort = 17345
+const pairTimeout = 2 * time.Minute
+
+//dbMu serializes shell commands and API requests on vaults,
+//shell command gives it up while it waits for user
+var dbMu sync.Mutex
+
+//dbHeld is set while shell command holds dbMu, only shell goroutine uses it
+var dbHeld bool
+
+//userInput runs f, which waits for user, with vaults unlocked,
+//so API requests aren't stuck behind prompts or full screen mode
+func userInput(f func()) {
+        if dbHeld {
+                dbMu.Unlock()
+                defer dbMu.Lock()
+        }
+        f()
+}
+
+type apiClient struct {
+        Name  string    `json:"name"`
+        Hash  string    `json:"hash"` //sha256 of the token
+        Added time.Time `json:"added"`
+}
+
+//pairRequest waits for user decision in the shell
+type pairRequest struct {
+        id     int
+        client string
+        addr   string
+        done   chan bool
+}
+
+type apiServer struct {
+        sync.Mutex
+        srv     *http.Server
+        addr    string
+        clients []apiClient
+        pending []*pairRequest
+        nextID  int
+}
+
+//api is running server, nil when stopped
+var api *apiServer
+
+func clientsFile() (string, error) {
+        dir, err := os.UserConfigDir()
+        if err != nil {
+                return "", err
+        }
+        return filepath.Join(dir, "pass", "clients.json"), nil
+}
+
+func loadClients() ([]apiClient, error) {
+        fn, err := clientsFile()
+        if err != nil {
+                return nil, err
+        }
+        data, err := ioutil.ReadFile(fn)
+        if os.IsNotExist(err) {
+                return nil, nil
+        }
+        if err != nil {
+                return nil, err
+        }
+        var c []apiClient
+        err = json.Unmarshal(data, &c)
+        return c, err
+}
+
+//saveClients is called with server locked
+func (s *apiServer) saveClients() error {
+        fn, err := clientsFile()
+        if err != nil {
+                return err
+        }
+        if err = os.MkdirAll(filepath.Dir(fn), 0700); err != nil {
+                return err
+        }
+        data, _ := json.MarshalIndent(s.clients, "", "  ")
+        return writeSecretFile(fn, data)
+}
+
+func tokenHash(t string) string {
+        h := sha256.Sum256([]byte(t))
+        return hex.EncodeToString(h[:])
+}
+
+//passAPI starts or stops the server
+func passAPI(r *bufio.Reader, a *Args) {
+        if api != nil {
+                fmt.Printf("API is running on %s, stop it (y/N)> ", api.addr)
+                c, _ := r.ReadString('\n')
+                if strings.ToLower(strings.TrimSpace(c)) == "y" {
+                        api.srv.Close()
+                        api.denyAll()
+                        api = nil
+                        fmt.Println("API stopped")
+                }
+                return
+        }
+
+        p := a.opt(r, fmt.Sprintf("Port [%d]", defaultAPIPort))
+        port := defaultAPIPort
+        if p != "" {
+                n, err := strconv.Atoi(p)
+                if err != nil || n <= 0 || n > 65535 {
+                        fmt.Println("Invalid port")
+                        return
+                }
+                port = n
+        }
+
+        clients, err := loadClients()
+        if err != nil {
+                fmt.Printf("Error reading clients %s\n", err)
+                return
+        }
+        s := &apiServer{addr: fmt.Sprintf("127.0.0.1:%d", port), clients: clients}
+        ln, err := net.Listen("tcp", s.addr)
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+        mux := http.NewServeMux()
+        mux.HandleFunc("/v1/pair", s.handlePair)
+        mux.HandleFunc("/v1/search", s.auth(s.handleSearch))
+        mux.HandleFunc("/v1/login", s.auth(s.handleLogin(false)))
+        mux.HandleFunc("/v1/password", s.auth(s.handleLogin(true)))
+        s.srv = &http.Server{Handler: s.local(mux), ReadTimeout: 10 * time.Second}
+        go s.srv.Serve(ln)
+        api = s
+        fmt.Printf("API listening on http://%s, %d paired clients\n", s.addr, len(clients))
+}
+
+func apiError(w http.ResponseWriter, code int, msg string) {
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(code)
+        json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+func apiReply(w http.ResponseWriter, v interface{}) {
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(v)
+}
+
+//local rejects requests with foreign Host header, web pages
+//can't reach the server through DNS rebinding then
+func (s *apiServer) local(h http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                host := r.Host
+                if hn, _, err := net.SplitHostPort(host); err == nil {
+                        host = hn
+                }
+                if host != "127.0.0.1" && host != "localhost" {
+                        apiError(w, http.StatusForbidden, "Invalid host")
+                        return
+                }
+                h.ServeHTTP(w, r)
+        })
+}
+
+func (s *apiServer) auth(h func(w http.ResponseWriter, r *http.Request, c string)) http.HandlerFunc {
+        return func(w http.ResponseWriter, r *http.Request) {
+                t := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+                hash := tokenHash(t)
+                client := ""
+                s.Lock()
+                for _, c := range s.clients {
+                        if subtle.ConstantTimeCompare([]byte(c.Hash), []byte(hash)) == 1 {
+                                client = c.Name
+                        }
+                }
+                s.Unlock()
+                if t == "" || client == "" {
+                        apiError(w, http.StatusUnauthorized, "Pair first")
+                        return
+                }
+                h(w, r, client)
+        }
+}
+
+func (s *apiServer) handlePair(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+                apiError(w, http.StatusMethodNotAllowed, "Use POST")
+                return
+        }
+        var req struct {
+                Client string `json:"client"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Client) == "" {
+                apiError(w, http.StatusBadRequest, "Client name required")
+                return
+        }
+
+        s.Lock()
+        s.nextID++
+        p := &pairRequest{id: s.nextID, client: strings.TrimSpace(req.Client), addr: r.RemoteAddr, done: make(chan bool, 1)}
+        s.pending = append(s.pending, p)
+        s.Unlock()
+
+        notify("Client %q asks for access to the vaults, request %d, use approve or deny\n", p.client, p.id)
+
+        ok := false
+        select {
+        case ok = <-p.done:
+        case <-time.After(pairTimeout):
+        case <-r.Context().Done():
+        }
+        s.Lock()
+        s.removePending(p)
+        if !ok {
+                s.Unlock()
+                apiError(w, http.StatusForbidden, "Access denied")
+                return
+        }
+        b := make([]byte, 32)
+        rand.Read(b)
+        t := hex.EncodeToString(b)
+        s.clients = append(s.clients, apiClient{p.client, tokenHash(t), time.Now()})
+        err := s.saveClients()
+        s.Unlock()
+        if err != nil {
+                fmt.Printf("Error saving clients %s\n", err)
+        }
+        apiReply(w, map[string]string{"token": t})
+}
+
+func (s *apiServer) removePending(p *pairRequest) {
+        for i := range s.pending {
+                if s.pending[i] == p {
+                        s.pending = append(s.pending[:i], s.pending[i + 1:]...)
+                        return
+                }
+        }
+}
+
+func (s *apiServer) denyAll() {
+        s.Lock()
+        defer s.Unlock()
+        for _, p := range s.pending {
+                p.done <- false
+        }
+        s.pending = nil
+}
+
+type apiRecord struct {
+        Id       string   `json:"id"`
+        Path     string   `json:"path"`
+        Login    string   `json:"login"`
+        Urls     []string `json:"urls,omitempty"`
+        Password string   `json:"password,omitempty"`
+}
+
+func (s *apiServer) handleSearch(w http.ResponseWriter, r *http.Request, client string) {
+        dbMu.Lock()
+        l, _ := (&localSecrets{db}).list(r.URL.Query().Get("q"))
+        dbMu.Unlock()
+        out := []apiRecord{}
+        for _, e := range l {
+                out = append(out, apiRecord{Id: e.Id, Path: e.Path, Login: e.Login, Urls: e.Urls})
+        }
+        apiReply(w, out)
+}
+
+func (s *apiServer) handleLogin(withPass bool) func(w http.ResponseWriter, r *http.Request, client string) {
+        return func(w http.ResponseWriter, r *http.Request, client string) {
+                q := r.URL.Query()
+                u, err := url.Parse(q.Get("url"))
+                if err != nil || u.Host == "" {
+                        apiError(w, http.StatusBadRequest, "Absolute url required")
+                        return
+                }
+                c := credential{protocol: u.Scheme, host: u.Host, path: strings.TrimPrefix(u.Path, "/"), username: q.Get("user")}
+
+                //vaults are locked for lookup only, notify may wait for full screen mode
+                var p []byte
+                dbMu.Lock()
+                ls := &localSecrets{db}
+                e, _ := findCredential(ls, c)
+                if e != nil && withPass {
+                        p, err = ls.get(e.Id, "")
+                }
+                dbMu.Unlock()
+                if e == nil {
+                        apiError(w, http.StatusNotFound, "No matching record")
+                        return
+                }
+                if err != nil {
+                        apiError(w, http.StatusNotFound, err.Error())
+                        return
+                }
+                out := apiRecord{Id: e.Id, Path: e.Path, Login: e.Login, Urls: e.Urls}
+                if withPass {
+                        out.Password = string(p)
+                        notify("Client %q got password of %s\n", client, e.Path)
+                }
+                apiReply(w, out)
+        }
+}
+
+//decide resolves pending pairing request chosen by user
+func decide(r *bufio.Reader, a *Args, allow bool) {
+        if api == nil {
+                fmt.Println("API is not running")
+                return
+        }
+        api.Lock()
+        pending := append([]*pairRequest(nil), api.pending...)
+        api.Unlock()
+        if len(pending) == 0 {
+                fmt.Println("No pending requests")
+                return
+        }
+
+        //request id may be given as argument, it is asked for
+        //only when there are several requests to choose from
+        p := pending[0]
+        if len(pending) > 1 || a.inline() {
+                if !a.inline() {
+                        for _, q := range pending {
+                                fmt.Printf("%d:\t%s\t%s\n", q.id, q.client, q.addr)
+                        }
+                }
+                id, _ := strconv.Atoi(a.next(r, "Request"))
+                p = nil
+                for _, q := range pending {
+                        if q.id == id {
+                                p = q
+                        }
+                }
+                if p == nil {
+                        fmt.Println("Invalid request")
+                        return
+                }
+        }
+        api.Lock()
+        api.removePending(p)
+        api.Unlock()
+        p.done <- allow
+        if allow {
+                fmt.Printf("Client %q approved\n", p.client)
+        } else {
+                fmt.Printf("Client %q denied\n", p.client)
+        }
+}
+
+func passApprove(r *bufio.Reader, a *Args) {
+        decide(r, a, true)
+}
+
+func passDeny(r *bufio.Reader, a *Args) {
+        decide(r, a, false)
+}
+
+//passClients lists paired clients and revokes their tokens
+func passClients(r *bufio.Reader, a *Args) {
+        clients, err := loadClients()
+        if api != nil {
+                api.Lock()
+                clients = append([]apiClient(nil), api.clients...)
+                api.Unlock()
+        }
+        if err != nil {
+                fmt.Printf("Error reading clients %s\n", err)
+                return
+        }
+        if len(clients) == 0 {
+                fmt.Println("No paired clients")
+                return
+        }
+        sort.SliceStable(clients, func(i, j int) bool { return clients[i].Added.Before(clients[j].Added) })
+        for i, c := range clients {
+                fmt.Printf("%d:\t%s\tpaired: %s\n", i + 1, c.Name, formatTime(c.Added))
+        }
+
+        n := a.opt(r, "Client to revoke (empty to skip)")
+        if n == "" {
+                return
+        }
+        i, err := strconv.Atoi(n)
+        if err != nil || i < 1 || i > len(clients) {
+                fmt.Println("Invalid client")
+                return
+        }
+        s := api
+        if s == nil {
+                s = &apiServer{}
+        }
+        s.Lock()
+        defer s.Unlock()
+        s.clients = append(clients[:i - 1], clients[i:]...)
+        if err = s.saveClients(); err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+        fmt.Println("Client revoked")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+        "fmt"
+        "bufio"
+        "errors"
+        "strings"
+)
+
+//Args is command line of the shell split into positional arguments and flags:
+//  paste "work/my bank" --field=pin
+//Flags are --name or --name=value. Commands take their arguments in the order
+//they used to ask for them, missing required ones are still asked for
+type Args struct {
+        pos   []string
+        flags map[string]string
+        next_ int //next positional argument to take
+}
+
+//parseLine splits command line into command and its arguments,
+//single and double quotes group words, backslash escapes next character
+func parseLine(l string) (string, *Args, error) {
+        var words []string
+        var w strings.Builder
+        inWord := false
+        var quote rune
+        escaped := false
+        for _, c := range l {
+                switch {
+                case escaped:
+                        w.WriteRune(c)
+                        escaped = false
+                case c == '\\' && quote != '\'':
+                        escaped, inWord = true, true
+                case quote != 0:
+                        if c == quote {
+                                quote = 0
+                        } else {
+                                w.WriteRune(c)
+                        }
+                case c == '"' || c == '\'':
+                        quote, inWord = c, true
+                case c == ' ' || c == '\t':
+                        if inWord {
+                                words = append(words, w.String())
+                                w.Reset()
+                                inWord = false
+                        }
+                default:
+                        w.WriteRune(c)
+                        inWord = true
+                }
+        }
+        if quote != 0 {
+                return "", nil, errors.New("Unterminated quote")
+        }
+        if inWord {
+                words = append(words, w.String())
+        }
+
+        a := &Args{flags: map[string]string{}}
+        if len(words) == 0 {
+                return "", a, nil
+        }
+        for i, s := range words[1:] {
+                if s == "--" {
+                        //everything after -- is positional
+                        a.pos = append(a.pos, words[i + 2:]...)
+                        break
+                }
+                if strings.HasPrefix(s, "--") && len(s) > 2 {
+                        kv := strings.SplitN(s[2:], "=", 2)
+                        if len(kv) == 1 {
+                                kv = append(kv, "")
+                        }
+                        a.flags[kv[0]] = kv[1]
+                        continue
+                }
+                a.pos = append(a.pos, s)
+        }
+        return words[0], a, nil
+}
+
+//noArgs is given to commands called from other commands
+func noArgs() *Args {
+        return &Args{flags: map[string]string{}}
+}
+
+//inline reports if command got any arguments on its command line,
+//optional questions aren't asked then
+func (a *Args) inline() bool {
+        return len(a.pos) > 0 || len(a.flags) > 0
+}
+
+func (a *Args) has(flag string) bool {
+        _, ok := a.flags[flag]
+        return ok
+}
+
+func (a *Args) flag(name string) string {
+        return a.flags[name]
+}
+
+//next returns next positional argument or asks for it
+func (a *Args) next(r *bufio.Reader, prompt string) string {
+        if a.next_ < len(a.pos) {
+                a.next_++
+                return a.pos[a.next_ - 1]
+        }
+        fmt.Print(prompt + "> ")
+        s, _ := r.ReadString('\n')
+        return strings.TrimSpace(s)
+}
+
+//opt is like next, but returns empty string instead of asking
+//when command got its arguments inline
+func (a *Args) opt(r *bufio.Reader, prompt string) string {
+        if a.next_ >= len(a.pos) && a.inline() {
+                return ""
+        }
+        return a.next(r, prompt)
+}
+
+//optFlag returns value of --name flag, it is asked for
+//only when command got no arguments inline
+func (a *Args) optFlag(r *bufio.Reader, name, prompt string) string {
+        if a.inline() {
+                return a.flags[name]
+        }
+        fmt.Print(prompt + "> ")
+        s, _ := r.ReadString('\n')
+        return strings.TrimSpace(s)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+        "bufio"
+        "reflect"
+        "strings"
+        "testing"
+)
+
+func TestParseLine(t *testing.T) {
+        tests := []struct {
+                line  string
+                cmd   string
+                pos   []string
+                flags map[string]string
+                ok    bool
+        }{
+                {"", "", nil, map[string]string{}, true},
+                {"   list  ", "list", nil, map[string]string{}, true},
+                {"paste web/github", "paste", []string{"web/github"}, map[string]string{}, true},
+                {`paste "work/my bank" --field=pin`, "paste", []string{"work/my bank"}, map[string]string{"field": "pin"}, true},
+                {`show 'it''s' "a \"b\""`, "show", []string{"its", `a "b"`}, map[string]string{}, true},
+                {`show my\ bank 'c:\dir'`, "show", []string{"my bank", `c:\dir`}, map[string]string{}, true},
+                {"list --json --format=text", "list", nil, map[string]string{"json": "", "format": "text"}, true},
+                {"add --x=a=b", "add", nil, map[string]string{"x": "a=b"}, true},
+                {"rename old -- --new", "rename", []string{"old", "--new"}, map[string]string{}, true},
+                {`edit ""`, "edit", []string{""}, map[string]string{}, true},
+                {"mv a\tb", "mv", []string{"a", "b"}, map[string]string{}, true},
+                {`paste "work/my bank`, "", nil, nil, false},
+                {`paste 'x`, "", nil, nil, false},
+        }
+        for _, tc := range tests {
+                c, a, err := parseLine(tc.line)
+                if (err == nil) != tc.ok {
+                        t.Errorf("%q: error %v", tc.line, err)
+                        continue
+                }
+                if !tc.ok {
+                        continue
+                }
+                if c != tc.cmd || !reflect.DeepEqual(a.pos, tc.pos) || !reflect.DeepEqual(a.flags, tc.flags) {
+                        t.Errorf("%q: got %q %q %v", tc.line, c, a.pos, a.flags)
+                }
+        }
+}
+
+func TestArgsPrompt(t *testing.T) {
+        r := bufio.NewReader(strings.NewReader("typed\n"))
+        _, a, _ := parseLine("paste web/github")
+        if s := a.next(r, "Nickname"); s != "web/github" {
+                t.Errorf("inline argument %q", s)
+        }
+        //optional argument isn't asked for when command got arguments
+        if s := a.opt(r, "Field"); s != "" {
+                t.Errorf("optional argument %q", s)
+        }
+        if s := a.next(r, "Field"); s != "typed" {
+                t.Errorf("asked argument %q", s)
+        }
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+        "fmt"
+        "time"
+        "bufio"
+        "strings"
+        "encoding/base64"
+)
+
+const auditMinLength = 12
+const auditMinScore = 3 //"good" or better
+
+//penalties subtracted from 100 points of every audited record
+const (
+        penaltyBreached = 50
+        penaltyReused   = 40
+        penaltyWeak     = 30
+        penaltyShort    = 20
+        penaltyPattern  = 10
+        penaltyOld      = 10
+)
+
+//auditResult holds problems found in a single record
+type auditResult struct {
+        nick   string
+        issues []string
+        score  int
+        pwned  int //times seen in breaches
+}
+
+func passAudit(r *bufio.Reader, a *Args) {
+        if len(db.records) == 0 && !jsonOutput() {
+                fmt.Println("No records found")
+                return
+        }
+
+        //breach check is optional, it needs local copy of Pwned Passwords
+        fn := expandHome(a.opt(r, "Pwned passwords file (optional)"))
+        var h *hibpFile
+        if fn != "" {
+                var err error
+                h, err = openHIBP(fn)
+                if err != nil {
+                        fmt.Printf("Error opening file %s\n", err)
+                        return
+                }
+                defer h.Close()
+        }
+
+        res, err := auditRecords(time.Duration(defaultMaxAge) * 24 * time.Hour, h)
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+        if jsonOutput() {
+                printJSON(jsonAudit(res))
+                return
+        }
+        printAudit(res)
+}
+
+func auditRecords(maxAge time.Duration, h *hibpFile) ([]auditResult, error) {
+        //reused passwords are found by grouping records on decoded password
+        reuse := map[string][]string{}
+        plain := make([]string, len(db.records))
+        for i, v := range db.records {
+                p, err := base64.StdEncoding.DecodeString(v.pass)
+                if err != nil {
+                        return nil, fmt.Errorf("record %s: %s", v.path(), err)
+                }
+                plain[i] = string(p)
+                if len(p) > 0 {
+                        reuse[plain[i]] = append(reuse[plain[i]], v.path())
+                }
+        }
+
+        var out []auditResult
+        for i, v := range db.records {
+                p := plain[i]
+                if p == "" {
+                        //otp only or similar records have nothing to audit
+                        continue
+                }
+
+                a := auditResult{nick: v.path(), score: 100}
+                if h != nil {
+                        c, err := h.count([]byte(p))
+                        if err != nil {
+                                return nil, fmt.Errorf("breach check of %s: %s", v.path(), err)
+                        }
+                        if c > 0 {
+                                a.pwned = c
+                                a.add(penaltyBreached, fmt.Sprintf("found in breaches %d times", c))
+                        }
+                }
+                if n := reuse[p]; len(n) > 1 {
+                        var others []string
+                        for _, o := range n {
+                                if o != a.nick {
+                                        others = append(others, o)
+                                }
+                        }
+                        a.add(penaltyReused, "reused in " + strings.Join(others, ", "))
+                }
+                if l := len([]rune(p)); l < auditMinLength {
+                        a.add(penaltyShort, fmt.Sprintf("short, %d characters", l))
+                }
+                st := estimateStrength(p)
+                if st.score < auditMinScore {
+                        a.add(penaltyWeak, st.String())
+                }
+                for _, w := range st.warnings {
+                        a.add(penaltyPattern, w)
+                }
+                if v.changed.IsZero() || time.Since(v.changed) > maxAge {
+                        a.add(penaltyOld, "not changed for " + v.passAge())
+                }
+                out = append(out, a)
+        }
+        return out, nil
+}
+
+func (a *auditResult) add(penalty int, issue string) {
+        a.issues = append(a.issues, issue)
+        a.score -= penalty
+        if a.score < 0 {
+                a.score = 0
+        }
+}
+
+type jsonAuditRecord struct {
+        Path   string   `json:"path"`
+        Score  int      `json:"score"`
+        Pwned  int      `json:"pwned"`
+        Issues []string `json:"issues"`
+}
+
+func jsonAudit(res []auditResult) map[string]interface{} {
+        recs := []jsonAuditRecord{}
+        total, bad := 0, 0
+        for _, a := range res {
+                total += a.score
+                if len(a.issues) > 0 {
+                        bad++
+                }
+                issues := a.issues
+                if issues == nil {
+                        issues = []string{}
+                }
+                recs = append(recs, jsonAuditRecord{a.nick, a.score, a.pwned, issues})
+        }
+        score := 100
+        if len(res) > 0 {
+                score = total / len(res)
+        }
+        return map[string]interface{}{"score": score, "audited": len(res), "with_issues": bad, "records": recs}
+}
+
+func printAudit(res []auditResult) {
+        if len(res) == 0 {
+                fmt.Println("No passwords to audit")
+                return
+        }
+
+        total, bad, pwned := 0, 0, 0
+        for _, a := range res {
+                total += a.score
+                if a.pwned > 0 {
+                        pwned++
+                }
+                if len(a.issues) == 0 {
+                        continue
+                }
+                bad++
+                fmt.Printf("[%s]:\tscore %d\n", a.nick, a.score)
+                for _, i := range a.issues {
+                        fmt.Printf("\t- %s\n", i)
+                }
+        }
+        fmt.Printf("%d of %d passwords have issues\n", bad, len(res))
+        if pwned > 0 {
+                fmt.Printf("%d passwords found in known breaches, change them now\n", pwned)
+        }
+        fmt.Printf("Vault score: %d/100\n", total / len(res))
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+        "os"
+        "fmt"
+        "time"
+        "bufio"
+        "errors"
+        "strconv"
+        "strings"
+        "io/ioutil"
+        "path/filepath"
+)
+
+//Defaults come from config file, UserConfigDir/pass/config.toml
+//(~/.config/pass/config.toml on Linux), or the file named by PASS_CONFIG:
+//  vault = "~/secrets.pass"
+//  [clipboard]
+//  timeout = "20s"
+//  command = "wl-copy"
+//Every setting is overridden by PASS_<SECTION>_<KEY> variable, e.g.
+//PASS_CLIPBOARD_TIMEOUT, and by --section.key=value given before command:
+//  pass --clipboard.timeout=5s paste web/github
+//Only part of TOML used here is understood: [section] headers,
+//key = value lines, quoted strings, integers, booleans and # comments
+const configEnv = "PASS_CONFIG"
+
+//vaultFile is database of command line commands and agent
+//when none is given, and default of load and open
+var vaultFile = ""
+
+//promptRetries is how many times required value is asked for
+var promptRetries = 2
+
+var clipboardTimeout = 10 * time.Second
+
+//clipboardCommand replaces clipboard utility found automatically,
+//secret is written to its stdin
+var clipboardCommand = ""
+
+//screenLockAfter is idle time after which full screen mode locks
+var screenLockAfter = 5 * time.Minute
+
+type setting struct {
+        key  string
+        help string
+        str  bool //value is string, quoted on output
+        get  func() string
+        set  func(string) error
+        from string //where value comes from
+}
+
+var settings = []*setting{
+        {key: "vault", help: "database used when none is given", str: true,
+         get: func() string { return vaultFile },
+         set: func(s string) error { vaultFile = expandHome(s); return nil }},
+        {key: "retries", help: "times required value is asked for",
+         get: func() string { return strconv.Itoa(promptRetries) },
+         set: func(s string) error { return setInt(&promptRetries, s, 1, 10) }},
+        {key: "clipboard.timeout", help: "time after which clipboard is cleared", str: true,
+         get: func() string { return clipboardTimeout.String() },
+         set: func(s string) error { return setDuration(&clipboardTimeout, s) }},
+        {key: "clipboard.command", help: "command to copy with, empty finds one", str: true,
+         get: func() string { return clipboardCommand },
+         set: func(s string) error {
+                 if s != "" && strings.TrimSpace(s) == "" {
+                         return errors.New("command can't be blank, leave it empty to find one")
+                 }
+                 clipboardCommand = strings.TrimSpace(s)
+                 return nil
+         }},
+        {key: "lock.agent", help: "agent forgets pass phrase after this idle time", str: true,
+         get: func() string { return agentTimeout.String() },
+         set: func(s string) error { return setDuration(&agentTimeout, s) }},
+        {key: "lock.screen", help: "full screen mode locks after this idle time", str: true,
+         get: func() string { return screenLockAfter.String() },
+         set: func(s string) error { return setDuration(&screenLockAfter, s) }},
+        {key: "kdf.cost", help: "log2 of scrypt N for vault keys, older files get it on save",
+         get: func() string { return strconv.Itoa(kdfCost) },
+         set: func(s string) error { return setInt(&kdfCost, s, kdfMinCost, kdfMaxCost) }},
+        {key: "policy.min_strength", help: "minimum strength of new passwords 0-4",
+         get: func() string { return strconv.Itoa(minStrength) },
+         set: func(s string) error { return setInt(&minStrength, s, 0, len(scoreNames) - 1) }},
+        {key: "policy.reject_weak", help: "reject weaker passwords instead of warning",
+         get: func() string { return strconv.FormatBool(rejectWeak) },
+         set: func(s string) error { return setBool(&rejectWeak, s) }},
+        {key: "output.format", help: "output of read commands, text or json", str: true,
+         get: func() string { return outputFormat },
+         set: func(s string) error {
+                 if s != "text" && s != "json" {
+                         return fmt.Errorf("Unknown format %s", s)
+                 }
+                 outputFormat = s
+                 return nil
+         }},
+        {key: "output.secrets", help: "json output has passwords and secret fields",
+         get: func() string { return strconv.FormatBool(outputSecrets) },
+         set: func(s string) error { return setBool(&outputSecrets, s) }},
+}
+
+//configPath is file settings were read from, configFound tells if it exists
+var configPath string
+var configFound bool
+
+//configArgs are --key=value arguments given before command
+var configArgs []string
+
+func setInt(v *int, s string, min, max int) error {
+        n, err := strconv.Atoi(s)
+        if err != nil || n < min || n > max {
+                return fmt.Errorf("expected number %d-%d, got %s", min, max, s)
+        }
+        *v = n
+        return nil
+}
+
+func setBool(v *bool, s string) error {
+        b, err := strconv.ParseBool(s)
+        if err != nil {
+                return fmt.Errorf("expected true or false, got %s", s)
+        }
+        *v = b
+        return nil
+}
+
+//setDuration takes Go duration like 90s or 15m, plain number is seconds
+func setDuration(v *time.Duration, s string) error {
+        if n, err := strconv.Atoi(s); err == nil {
+                s = strconv.Itoa(n) + "s"
+        }
+        d, err := time.ParseDuration(s)
+        if err != nil || d <= 0 {
+                return fmt.Errorf("expected duration like 30s or 15m, got %s", s)
+        }
+        *v = d
+        return nil
+}
+
+func expandHome(s string) string {
+        if s != "~" && !strings.HasPrefix(s, "~/") {
+                return s
+        }
+        home, err := os.UserHomeDir()
+        if err != nil {
+                return s
+        }
+        return filepath.Join(home, s[1:])
+}
+
+func findSetting(key string) *setting {
+        for _, s := range settings {
+                if s.key == key {
+                        return s
+                }
+        }
+        return nil
+}
+
+func settingEnv(key string) string {
+        return "PASS_" + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+}
+
+func defaultConfigPath() string {
+        dir, err := os.UserConfigDir()
+        if err != nil {
+                return ""
+        }
+        return filepath.Join(dir, "pass", "config.toml")
+}
+
+//loadConfig applies config file, environment and --key=value arguments
+//in this order and returns arguments left after the settings
+func loadConfig(args []string) ([]string, error) {
+        for _, s := range settings {
+                s.from = "default"
+        }
+        configPath = os.Getenv(configEnv)
+        var flags [][]string
+        for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+                kv := strings.SplitN(args[0][2:], "=", 2)
+                if len(kv) != 2 {
+                        return nil, fmt.Errorf("Expected --setting=value, got %s", args[0])
+                }
+                configArgs = append(configArgs, args[0])
+                if kv[0] == "config" {
+                        configPath = kv[1]
+                } else {
+                        flags = append(flags, kv)
+                }
+                args = args[1:]
+        }
+
+        //missing default file is fine, missing named one is not
+        named := configPath != ""
+        if !named {
+                configPath = defaultConfigPath()
+        }
+        if configPath != "" {
+                data, err := ioutil.ReadFile(configPath)
+                if err != nil && (named || !os.IsNotExist(err)) {
+                        return nil, err
+                }
+                if err == nil {
+                        configFound = true
+                        if err = applyConfig(string(data)); err != nil {
+                                return nil, fmt.Errorf("%s:%s", configPath, err)
+                        }
+                }
+        }
+
+        for _, s := range settings {
+                env := settingEnv(s.key)
+                if v, ok := os.LookupEnv(env); ok {
+                        if err := s.set(v); err != nil {
+                                return nil, fmt.Errorf("%s: %s", env, err)
+                        }
+                        s.from = "env " + env
+                }
+        }
+
+        for _, kv := range flags {
+                s := findSetting(kv[0])
+                if s == nil {
+                        return nil, fmt.Errorf("Unknown setting --%s", kv[0])
+                }
+                if err := s.set(kv[1]); err != nil {
+                        return nil, fmt.Errorf("--%s: %s", kv[0], err)
+                }
+                s.from = "flag"
+        }
+        return args, nil
+}
+
+//applyConfig parses config file content, errors start with line number
+func applyConfig(data string) error {
+        section := ""
+        s := bufio.NewScanner(strings.NewReader(data))
+        for n := 1; s.Scan(); n++ {
+                l := strings.TrimSpace(stripComment(s.Text()))
+                if l == "" {
+                        continue
+                }
+                if strings.HasPrefix(l, "[") {
+                        if !strings.HasSuffix(l, "]") {
+                                return fmt.Errorf("%d: invalid section %s", n, l)
+                        }
+                        section = strings.TrimSpace(l[1:len(l) - 1])
+                        continue
+                }
+                i := strings.Index(l, "=")
+                if i <= 0 {
+                        return fmt.Errorf("%d: expected key = value", n)
+                }
+                key := strings.TrimSpace(l[:i])
+                if section != "" {
+                        key = section + "." + key
+                }
+                v, err := parseValue(strings.TrimSpace(l[i + 1:]))
+                if err != nil {
+                        return fmt.Errorf("%d: %s", n, err)
+                }
+                st := findSetting(key)
+                if st == nil {
+                        return fmt.Errorf("%d: unknown setting %s", n, key)
+                }
+                if err = st.set(v); err != nil {
+                        return fmt.Errorf("%d: %s: %s", n, key, err)
+                }
+                st.from = "file"
+        }
+        return s.Err()
+}
+
+//stripComment cuts # comment, # inside quoted string is kept
+func stripComment(l string) string {
+        var quote byte
+        for i := 0; i < len(l); i++ {
+                c := l[i]
+                switch {
+                case quote == '"' && c == '\\':
+                        i++
+                case quote != 0:
+                        if c == quote {
+                                quote = 0
+                        }
+                case c == '"' || c == '\'':
+                        quote = c
+                case c == '#':
+                        return l[:i]
+                }
+        }
+        return l
+}
+
+//parseValue decodes "basic" and 'literal' strings, integers and booleans
+func parseValue(v string) (string, error) {
+        switch {
+        case strings.HasPrefix(v, "\""):
+                return strconv.Unquote(v)
+        case strings.HasPrefix(v, "'"):
+                if len(v) < 2 || !strings.HasSuffix(v, "'") {
+                        return "", errors.New("unterminated string")
+                }
+                return v[1:len(v) - 1], nil
+        case v == "true" || v == "false":
+                return v, nil
+        }
+        if _, err := strconv.Atoi(v); err == nil {
+                return v, nil
+        }
+        return "", fmt.Errorf("value %s has to be quoted", v)
+}
+
+type jsonSetting struct {
+        Key    string `json:"key"`
+        Value  string `json:"value"`
+        Source string `json:"source"`
+}
+
+func showConfig() {
+        if jsonOutput() {
+                out := []jsonSetting{}
+                for _, s := range settings {
+                        out = append(out, jsonSetting{s.key, s.get(), s.from})
+                }
+                printJSON(map[string]interface{}{"file": configPath, "found": configFound, "settings": out})
+                return
+        }
+        state := ""
+        if !configFound {
+                state = " (not found)"
+        }
+        fmt.Printf("# %s%s\n", configPath, state)
+        for _, s := range settings {
+                v := s.get()
+                if s.str {
+                        v = strconv.Quote(v)
+                }
+                fmt.Printf("%s = %s\t# %s, %s\n", s.key, v, s.from, s.help)
+        }
+}
+
+//passConfig is "config [show]" of the shell
+func passConfig(r *bufio.Reader, a *Args) {
+        if len(a.pos) > 0 && a.pos[0] != "show" {
+                fmt.Printf("Unknown config command %s\n", a.pos[0])
+                return
+        }
+        showConfig()
+}
+
+//configCli is "pass config show [--json]"
+func configCli(args []string) int {
+        format, args, err := cliFormat(args)
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "Error %s\n", err)
+                return exitUsage
+        }
+        if len(args) > 1 || (len(args) == 1 && args[0] != "show") {
+                fmt.Fprintln(os.Stderr, "Usage: pass config show [--json]")
+                return exitUsage
+        }
+        outputFormat = format
+        showConfig()
+        return exitOK
+}
+
+//vaultPath asks for database file, configured vault is taken on empty input
+func vaultPath(r *bufio.Reader, a *Args) (string, error) {
+        if vaultFile == "" {
+                return mustPath(r, a, promptRetries)
+        }
+        n := a.next(r, fmt.Sprintf("Enter filename [%s]", vaultFile))
+        if n == "" {
+                n = vaultFile
+        }
+        return expandHome(n), nil
+}
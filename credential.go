@@ -0,0 +1,185 @@
+package main
+
+import (
+        "io"
+        "os"
+        "fmt"
+        "bufio"
+        "strings"
+        "net/url"
+)
+
+//pass works as git credential helper:
+//  git config --global credential.helper "/path/to/pass git-credential"
+//Records are matched by urls: host has to be the same, record url path,
+//if any, has to be prefix of requested path, and login has to match
+//username when git knows it. New credentials are stored in credentialFolder
+const credentialFolder = "git"
+
+//credential is what git sends and expects back, one key=value per line
+type credential struct {
+        protocol string
+        host     string
+        path     string
+        username string
+        password string
+}
+
+func readCredential(in io.Reader) (credential, error) {
+        var c credential
+        s := bufio.NewScanner(in)
+        for s.Scan() {
+                l := s.Text()
+                if l == "" {
+                        break
+                }
+                i := strings.Index(l, "=")
+                if i < 0 {
+                        return c, fmt.Errorf("Invalid line %q", l)
+                }
+                k, v := l[:i], l[i + 1:]
+                switch k {
+                case "protocol":
+                        c.protocol = v
+                case "host":
+                        c.host = v
+                case "path":
+                        c.path = v
+                case "username":
+                        c.username = v
+                case "password":
+                        c.password = v
+                case "url":
+                        u, err := url.Parse(v)
+                        if err != nil {
+                                return c, err
+                        }
+                        c.protocol, c.host, c.path = u.Scheme, u.Host, strings.TrimPrefix(u.Path, "/")
+                        if u.User != nil {
+                                c.username = u.User.Username()
+                        }
+                }
+        }
+        return c, s.Err()
+}
+
+func (c credential) url() string {
+        u := url.URL{Scheme: c.protocol, Host: c.host, Path: "/" + c.path}
+        if c.path == "" {
+                u.Path = ""
+        }
+        return u.String()
+}
+
+//matchURL scores how well record url matches requested credential,
+//-1 means no match, longer matching path gets higher score
+func (c credential) matchURL(s string) int {
+        if !strings.Contains(s, "://") {
+                s = "//" + s
+        }
+        u, err := url.Parse(s)
+        if err != nil || !strings.EqualFold(u.Host, c.host) {
+                return -1
+        }
+        if u.Scheme != "" && c.protocol != "" && u.Scheme != c.protocol {
+                return -1
+        }
+        p := strings.Trim(u.Path, "/")
+        if p == "" {
+                return 0
+        }
+        //path is known to git only with credential.useHttpPath
+        rp := strings.Trim(c.path, "/")
+        rp = strings.TrimSuffix(rp, ".git")
+        p = strings.TrimSuffix(p, ".git")
+        if rp != p && !strings.HasPrefix(rp, p + "/") {
+                return -1
+        }
+        return len(p)
+}
+
+//findCredential returns best matching record, nil if none
+func findCredential(s secrets, c credential) (*agentEntry, error) {
+        l, err := s.list("")
+        if err != nil {
+                return nil, err
+        }
+        var best *agentEntry
+        score := -1
+        for i := range l {
+                e := &l[i]
+                if c.username != "" && e.Login != c.username {
+                        continue
+                }
+                for _, u := range e.Urls {
+                        if m := c.matchURL(u); m > score {
+                                best, score = e, m
+                        }
+                }
+        }
+        return best, nil
+}
+
+//passCredential is "pass git-credential get|store|erase"
+func passCredential(args []string) int {
+        if len(args) != 1 {
+                fmt.Fprintln(os.Stderr, "Usage: pass git-credential get|store|erase")
+                return exitUsage
+        }
+        c, err := readCredential(os.Stdin)
+        if err != nil {
+                return cliError(err)
+        }
+        if c.host == "" {
+                return exitOK
+        }
+
+        switch args[0] {
+        case "get", "store", "erase":
+        default:
+                //git ignores operations it doesn't know, so do we
+                return exitOK
+        }
+
+        s, err := openSecrets()
+        if err != nil {
+                return cliError(err)
+        }
+        e, err := findCredential(s, c)
+        if err != nil {
+                return cliError(err)
+        }
+
+        switch args[0] {
+        case "get":
+                //nothing printed lets git ask user
+                if e == nil {
+                        return exitOK
+                }
+                var p []byte
+                if p, err = s.get(e.Id, ""); err != nil {
+                        //record without password is not an error for git
+                        return exitOK
+                }
+                fmt.Printf("username=%s\n", e.Login)
+                fmt.Printf("password=%s\n", p)
+        case "store":
+                if c.username == "" || c.password == "" {
+                        return exitOK
+                }
+                n := credentialFolder + "/" + c.username + "@" + strings.Replace(c.host, ":", "_", -1)
+                ne := agentEntry{Path: n, Login: c.username, Urls: []string{c.url()}}
+                if e != nil {
+                        ne = *e
+                }
+                err = s.store(ne, []byte(c.password))
+        case "erase":
+                if e != nil && c.password != "" {
+                        err = s.erase(e.Id, []byte(c.password))
+                }
+        }
+        if err != nil {
+                return cliError(err)
+        }
+        return exitOK
+}
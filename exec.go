@@ -0,0 +1,151 @@
+package main
+
+import (
+        "os"
+        "fmt"
+        "flag"
+        "bufio"
+        "errors"
+        "strings"
+        "os/exec"
+        "os/signal"
+)
+
+//pass exec runs command with secrets in its environment:
+//  pass exec --env DB_PASS=prod/db --env DB_USER=prod/db:login -- ./deploy.sh
+//Value is record path optionally followed by :field, where field is
+//login, otp or custom field name, password is used when field is omitted.
+//Secrets are given to the child process only, never written anywhere.
+//Exit code is the one of the command, once it is started
+type envFlags []string
+
+func (e *envFlags) String() string {
+        return strings.Join(*e, " ")
+}
+
+func (e *envFlags) Set(s string) error {
+        if !strings.Contains(s, "=") {
+                return errors.New("expected VAR=path[:field]")
+        }
+        *e = append(*e, s)
+        return nil
+}
+
+//envMapping is one variable resolved from the vault
+type envMapping struct {
+        name  string
+        path  string
+        field string
+}
+
+func parseEnvMapping(s string) (envMapping, error) {
+        i := strings.Index(s, "=")
+        if i <= 0 {
+                return envMapping{}, fmt.Errorf("Invalid mapping %q, expected VAR=path[:field]", s)
+        }
+        m := envMapping{name: strings.TrimSpace(s[:i])}
+        m.path = strings.TrimSpace(s[i + 1:])
+        if j := strings.LastIndex(m.path, ":"); j >= 0 {
+                m.path, m.field = m.path[:j], m.path[j + 1:]
+        }
+        if m.path == "" || strings.ContainsAny(m.name, " \t") {
+                return envMapping{}, fmt.Errorf("Invalid mapping %q, expected VAR=path[:field]", s)
+        }
+        return m, nil
+}
+
+//readEnvFile reads .env style mapping file: VAR=path[:field] per line,
+//empty lines and lines starting with # are skipped, export prefix is allowed
+func readEnvFile(fn string) ([]envMapping, error) {
+        f, err := os.Open(fn)
+        if err != nil {
+                return nil, err
+        }
+        defer f.Close()
+
+        var out []envMapping
+        s := bufio.NewScanner(f)
+        for n := 1; s.Scan(); n++ {
+                l := strings.TrimSpace(s.Text())
+                if l == "" || strings.HasPrefix(l, "#") {
+                        continue
+                }
+                l = strings.TrimSpace(strings.TrimPrefix(l, "export "))
+                //values may be quoted like in shell
+                if i := strings.Index(l, "="); i > 0 {
+                        l = l[:i + 1] + strings.Trim(l[i + 1:], "\"'")
+                }
+                m, err := parseEnvMapping(l)
+                if err != nil {
+                        return nil, fmt.Errorf("%s:%d: %s", fn, n, err)
+                }
+                out = append(out, m)
+        }
+        return out, s.Err()
+}
+
+//passExec is "pass exec [--env VAR=path[:field]]... [--env-file file] -- command args..."
+func passExec(args []string) int {
+        fs := flag.NewFlagSet("exec", flag.ContinueOnError)
+        var envs envFlags
+        fs.Var(&envs, "env", "set variable to record secret, VAR=path[:field]")
+        envFile := fs.String("env-file", "", "file with VAR=path[:field] lines")
+        if err := fs.Parse(args); err != nil {
+                return exitUsage
+        }
+        if fs.NArg() == 0 {
+                fmt.Fprintln(os.Stderr, "Usage: pass exec [--env VAR=path[:field]]... [--env-file file] -- command args...")
+                return exitUsage
+        }
+
+        var maps []envMapping
+        if *envFile != "" {
+                m, err := readEnvFile(*envFile)
+                if err != nil {
+                        return cliError(err)
+                }
+                maps = m
+        }
+        //command line overrides the file
+        for _, e := range envs {
+                m, err := parseEnvMapping(e)
+                if err != nil {
+                        fmt.Fprintf(os.Stderr, "Error %s\n", err)
+                        return exitUsage
+                }
+                maps = append(maps, m)
+        }
+        if len(maps) == 0 {
+                fmt.Fprintln(os.Stderr, "Error no variables to set, use --env or --env-file")
+                return exitUsage
+        }
+
+        s, err := openSecrets()
+        if err != nil {
+                return cliError(err)
+        }
+        env := os.Environ()
+        for _, m := range maps {
+                p, err := s.get(m.path, m.field)
+                if err != nil {
+                        fmt.Fprintf(os.Stderr, "Error %s: %s\n", m.name, err)
+                        return exitCode(err)
+                }
+                env = append(env, m.name + "=" + string(p))
+        }
+
+        c := exec.Command(fs.Arg(0), fs.Args()[1:]...)
+        c.Env = env
+        c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+        //interrupt from terminal goes to the child as well, let it decide,
+        //ignored signal would be inherited by the child, so catch it instead
+        signal.Notify(make(chan os.Signal, 1), os.Interrupt)
+        err = c.Run()
+        if ee, ok := err.(*exec.ExitError); ok {
+                return ee.ExitCode()
+        }
+        if err != nil {
+                return cliError(err)
+        }
+        return exitOK
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+        "fmt"
+        "os"
+        "io"
+        "time"
+        "bufio"
+        "bytes"
+        "strings"
+        "path/filepath"
+        "encoding/csv"
+        "encoding/json"
+        "encoding/base64"
+)
+
+//exportRecord is plaintext representation of Record used by JSON/CSV export
+type exportRecord struct {
+        Id       string        `json:"id,omitempty"`
+        Folder   string        `json:"folder,omitempty"`
+        Nick     string        `json:"nick"`
+        Login    string        `json:"login"`
+        Hint     string        `json:"hint,omitempty"`
+        Password string        `json:"password"`
+        Urls     []string      `json:"urls,omitempty"`
+        Notes    string        `json:"notes,omitempty"`
+        Tags     []string      `json:"tags,omitempty"`
+        Fields   []exportField `json:"fields,omitempty"`
+        Otp      string        `json:"otp,omitempty"`
+        SshKey   string        `json:"sshkey,omitempty"` //PEM private key
+        Created  string        `json:"created,omitempty"`
+        Modified string        `json:"modified,omitempty"`
+        Changed  string        `json:"changed,omitempty"`
+}
+
+//exportField carries decoded value even for secret fields
+type exportField struct {
+        Name   string `json:"name"`
+        Value  string `json:"value"`
+        Secret bool   `json:"secret,omitempty"`
+}
+
+var exportFormats = []string{"json", "csv", "vault"}
+
+func passExport(r *bufio.Reader, a *Args) {
+        if len(db.records) == 0 {
+                fmt.Println("No records found")
+                return
+        }
+
+        //export csv out.csv --filter=work
+        f := strings.ToLower(a.opt(r, fmt.Sprintf("Format (%s) [json]", strings.Join(exportFormats, "/"))))
+        if f == "" {
+                f = "json"
+        }
+        if f != "json" && f != "csv" && f != "vault" {
+                fmt.Printf("Unknown format: %s\n", f)
+                return
+        }
+
+        recs := db.filter(a.optFlag(r, "filter", "Filter (optional)"))
+        if len(recs) == 0 {
+                fmt.Println("No records found")
+                return
+        }
+
+        if f == "vault" {
+                exportVault(r, a, recs)
+                return
+        }
+
+        fn := expandHome(a.opt(r, "Enter filename (empty for stdout)"))
+
+        var b bytes.Buffer
+        var err error
+        if f == "json" {
+                err = writeJSON(&b, recs)
+        } else {
+                err = writeCSV(&b, recs)
+        }
+        if err != nil {
+                fmt.Printf("Error exporting records %s\n", err)
+                return
+        }
+
+        if fn == "" {
+                os.Stdout.Write(b.Bytes())
+                return
+        }
+
+        fmt.Println("Passwords will be written to file unencrypted")
+        fmt.Print("Type 'yes' to confirm> ")
+        c, _ := r.ReadString('\n')
+        if strings.TrimSpace(c) != "yes" {
+                fmt.Println("Export cancelled")
+                return
+        }
+
+        if err = writeExportFile(fn, b.Bytes()); err != nil {
+                fmt.Printf("Error writing file %s\n", err)
+                return
+        }
+        fmt.Printf("Exported %d records\n", len(recs))
+}
+
+//exportVault writes records into new database protected by separate pass phrase
+func exportVault(r *bufio.Reader, a *Args, recs []Record) {
+        fn, err := mustPath(r, a, promptRetries)
+        if err != nil {
+                return
+        }
+
+        fmt.Print("Enter export Pass phrase> ")
+        p, _ := readPassword()
+        fmt.Print("\rEnter export Pass phrase>                                      \r\n")
+        if len(p) == 0 {
+                fmt.Println("Pass phrase can't be empty")
+                return
+        }
+        fmt.Print("Repeat export Pass phrase> ")
+        p2, _ := readPassword()
+        fmt.Print("\rRepeat export Pass phrase>                                     \r\n")
+        if !bytes.Equal(p, p2) {
+                fmt.Println("Pass phrases don't match")
+                return
+        }
+        if !checkStrength(p, "Pass phrase") {
+                return
+        }
+
+        key, err := deriveKey(p, nil)
+        if err != nil {
+                fmt.Printf("Error deriving key %s\n", err)
+                return
+        }
+        data, err := encodeVault(serializeRecords(recs), key)
+        if err != nil {
+                fmt.Printf("Error encoding file %s\n", err)
+                return
+        }
+
+        if err = writeExportFile(fn, data); err != nil {
+                fmt.Printf("Error writing file %s\n", err)
+                return
+        }
+        fmt.Printf("Exported %d records\n", len(recs))
+}
+
+func toExportRecords(recs []Record) ([]exportRecord, error) {
+        out := make([]exportRecord, 0, len(recs))
+        for _, v := range recs {
+                p, err := base64.StdEncoding.DecodeString(v.pass)
+                if err != nil {
+                        return nil, fmt.Errorf("record %s: %s", v.path(), err)
+                }
+                e := exportRecord{
+                        Id:       v.id,
+                        Folder:   v.folder,
+                        Nick:     v.nick,
+                        Login:    v.login,
+                        Hint:     v.hint,
+                        Password: string(p),
+                        Urls:     v.urls,
+                        Notes:    v.notes,
+                        Tags:     v.tags,
+                        Otp:      v.otp,
+                        Created:  exportTime(v.created),
+                        Modified: exportTime(v.modified),
+                        Changed:  exportTime(v.changed),
+                }
+                if v.sshkey != "" {
+                        k, err := base64.StdEncoding.DecodeString(v.sshkey)
+                        if err != nil {
+                                return nil, fmt.Errorf("record %s: %s", v.path(), err)
+                        }
+                        e.SshKey = string(k)
+                }
+                for _, f := range v.fields {
+                        fv, err := v.fieldValue(f.name)
+                        if err != nil {
+                                return nil, fmt.Errorf("record %s: %s", v.path(), err)
+                        }
+                        e.Fields = append(e.Fields, exportField{f.name, string(fv), f.secret})
+                }
+                out = append(out, e)
+        }
+        return out, nil
+}
+
+func exportTime(t time.Time) string {
+        if t.IsZero() {
+                return ""
+        }
+        return t.UTC().Format(time.RFC3339)
+}
+
+func writeJSON(w io.Writer, recs []Record) error {
+        out, err := toExportRecords(recs)
+        if err != nil {
+                return err
+        }
+        e := json.NewEncoder(w)
+        e.SetIndent("", "  ")
+        return e.Encode(out)
+}
+
+func writeCSV(w io.Writer, recs []Record) error {
+        out, err := toExportRecords(recs)
+        if err != nil {
+                return err
+        }
+        c := csv.NewWriter(w)
+        c.Write([]string{"id", "folder", "nick", "login", "hint", "password", "urls", "tags", "notes", "fields", "otp",
+                         "sshkey", "created", "modified", "changed"})
+        for _, v := range out {
+                var f []string
+                for _, e := range v.Fields {
+                        f = append(f, e.Name + ": " + e.Value)
+                }
+                c.Write([]string{v.Id, v.Folder, v.Nick, v.Login, v.Hint, v.Password,
+                                 strings.Join(v.Urls, " "), strings.Join(v.Tags, ","),
+                                 v.Notes, strings.Join(f, "\n"), v.Otp,
+                                 v.SshKey, v.Created, v.Modified, v.Changed})
+        }
+        c.Flush()
+        return c.Error()
+}
+
+//writeExportFile creates file readable by owner only, existing file is never overwritten
+func writeExportFile(fn string, data []byte) error {
+        fn, err := filepath.Abs(fn)
+        if err != nil {
+                return err
+        }
+        f, err := os.OpenFile(fn, os.O_WRONLY | os.O_CREATE | os.O_EXCL, 0600)
+        if err != nil {
+                return err
+        }
+        defer f.Close()
+        _, err = f.Write(data)
+        return err
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+        "fmt"
+        "path"
+        "sort"
+        "bufio"
+        "strings"
+)
+
+//Records are organized in folders like work/github/alice, where
+//work/github is record folder and alice is its nickname.
+//Folders exist as long as there are records in them,
+//nickname has to be unique within its folder only
+
+//path returns full name of the record
+func (v *Record) path() string {
+        if v.folder == "" {
+                return v.nick
+        }
+        return v.folder + "/" + v.nick
+}
+
+//cleanFolder resolves folder name relative to current folder,
+//result has no leading or trailing slashes, root folder is empty string
+func cleanFolder(n string) string {
+        return db.cleanFolder(n)
+}
+
+func (d *Database) cleanFolder(n string) string {
+        if !strings.HasPrefix(n, "/") {
+                n = "/" + d.cwd + "/" + n
+        }
+        return strings.Trim(path.Clean(n), "/")
+}
+
+//resolvePath splits record name relative to current folder into folder and nickname
+func resolvePath(n string) (string, string) {
+        return db.resolvePath(n)
+}
+
+func (d *Database) resolvePath(n string) (string, string) {
+        p := d.cleanFolder(n)
+        i := strings.LastIndex(p, "/")
+        if i < 0 {
+                return "", p
+        }
+        return p[:i], p[i + 1:]
+}
+
+//inFolder reports if folder f is folder dir or one of its subfolders
+func inFolder(f, dir string) bool {
+        return dir == "" || f == dir || strings.HasPrefix(f, dir + "/")
+}
+
+func folderExists(dir string) bool {
+        for _, v := range db.records {
+                if inFolder(v.folder, dir) {
+                        return true
+                }
+        }
+        return false
+}
+
+//promptFolder is shown in command prompt when not in root folder
+func promptFolder() string {
+        if db.cwd == "" {
+                return ""
+        }
+        return ":/" + db.cwd
+}
+
+func passCd(r *bufio.Reader, a *Args) {
+        n := a.next(r, "Folder")
+        if n == "" {
+                n = "/"
+        }
+        dir := cleanFolder(n)
+        if dir != "" && !folderExists(dir) {
+                fmt.Printf("Folder /%s is empty, records added here will create it\n", dir)
+        }
+        db.cwd = dir
+}
+
+func passLs(r *bufio.Reader, a *Args) {
+        subs, recs := folderContent(db.cwd)
+        if jsonOutput() {
+                if subs == nil {
+                        subs = []string{}
+                }
+                printJSON(map[string]interface{}{"folder": "/" + db.cwd, "folders": subs, "records": jsonRecords(recs)})
+                return
+        }
+        if len(subs) == 0 && len(recs) == 0 {
+                fmt.Println("No records found")
+                return
+        }
+        for _, s := range subs {
+                fmt.Printf("%s/\n", s)
+        }
+        for _, v := range recs {
+                fmt.Printf("%s\tlogin: %s\n", v.nick, v.login)
+        }
+}
+
+type jsonFolder struct {
+        Name    string       `json:"name"`
+        Folders []jsonFolder `json:"folders"`
+        Records []jsonRecord `json:"records"`
+}
+
+func passTree(r *bufio.Reader, a *Args) {
+        if jsonOutput() {
+                t := treeJSON(db.cwd)
+                t.Name = "/" + db.cwd
+                printJSON(t)
+                return
+        }
+        fmt.Printf("/%s\n", db.cwd)
+        printTree(db.cwd, "    ")
+}
+
+func printTree(dir, indent string) {
+        subs, recs := folderContent(dir)
+        for _, s := range subs {
+                fmt.Printf("%s%s/\n", indent, s)
+                printTree(strings.TrimPrefix(dir + "/" + s, "/"), indent + "    ")
+        }
+        for _, v := range recs {
+                fmt.Printf("%s%s\n", indent, v.nick)
+        }
+}
+
+func treeJSON(dir string) jsonFolder {
+        subs, recs := folderContent(dir)
+        f := jsonFolder{Name: dir[strings.LastIndex(dir, "/") + 1:], Folders: []jsonFolder{}, Records: jsonRecords(recs)}
+        for _, s := range subs {
+                f.Folders = append(f.Folders, treeJSON(strings.TrimPrefix(dir + "/" + s, "/")))
+        }
+        return f
+}
+
+//folderContent returns sorted names of direct subfolders and records of the folder
+func folderContent(dir string) ([]string, []Record) {
+        seen := map[string]bool{}
+        var subs []string
+        var recs []Record
+        for _, v := range db.records {
+                if v.folder == dir {
+                        recs = append(recs, v)
+                        continue
+                }
+                if !inFolder(v.folder, dir) {
+                        continue
+                }
+                rest := strings.TrimPrefix(strings.TrimPrefix(v.folder, dir), "/")
+                s := strings.SplitN(rest, "/", 2)[0]
+                if !seen[s] {
+                        seen[s] = true
+                        subs = append(subs, s)
+                }
+        }
+        sort.Strings(subs)
+        sort.Slice(recs, func(i, j int) bool { return recs[i].nick < recs[j].nick })
+        return subs, recs
+}
+
+//passMv moves record or whole folder into another folder
+func passMv(r *bufio.Reader, a *Args) {
+        n := a.next(r, "Record or folder")
+        if n == "" {
+                fmt.Println("Name can't be empty")
+                return
+        }
+        d := a.next(r, "Destination folder")
+        if d == "" {
+                d = "/"
+        }
+        dst := cleanFolder(d)
+
+        if v, err := findRecord(n); err == nil {
+                if _, err := findRecord("/" + dst + "/" + v.nick); err == nil {
+                        fmt.Printf("%s already present in /%s\n", v.nick, dst)
+                        return
+                }
+                v.folder = dst
+                v.touch(false)
+                fmt.Printf("Moved to /%s\n", v.path())
+                return
+        }
+
+        src := cleanFolder(n)
+        if src == "" || !folderExists(src) {
+                fmt.Printf("Error Record or folder %s not found\n", n)
+                return
+        }
+        if inFolder(dst, src) {
+                fmt.Println("Can't move folder into itself")
+                return
+        }
+
+        //new folder is destination plus last element of the source
+        base := path.Base(src)
+        target := strings.TrimPrefix(dst + "/" + base, "/")
+        var moved []int
+        for i, v := range db.records {
+                if !inFolder(v.folder, src) {
+                        continue
+                }
+                f := target + strings.TrimPrefix(v.folder, src)
+                if _, err := findRecord("/" + f + "/" + v.nick); err == nil {
+                        fmt.Printf("%s/%s already present, nothing moved\n", f, v.nick)
+                        return
+                }
+                moved = append(moved, i)
+        }
+        for _, i := range moved {
+                v := &db.records[i]
+                v.folder = target + strings.TrimPrefix(v.folder, src)
+                v.touch(false)
+        }
+        fmt.Printf("Moved %d records to /%s\n", len(moved), target)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+        "io"
+        "os"
+        "fmt"
+        "bufio"
+        "strings"
+        "strconv"
+        "crypto/sha1"
+        "encoding/hex"
+)
+
+//hibpFile is locally downloaded Have I Been Pwned Pwned Passwords SHA-1 file,
+//the "ordered by hash" edition: "HASH:COUNT" lines sorted by hash,
+//so it can be binary searched without loading into memory
+type hibpFile struct {
+        f    *os.File
+        size int64
+}
+
+//hibpLine is length of hash part of the line
+const hibpLine = 40
+
+func openHIBP(fn string) (*hibpFile, error) {
+        f, err := os.Open(fn)
+        if err != nil {
+                return nil, err
+        }
+        st, err := f.Stat()
+        if err != nil {
+                f.Close()
+                return nil, err
+        }
+        h := &hibpFile{f, st.Size()}
+
+        //file ordered by prevalence can't be searched, check first lines
+        _, a, next, err := h.lineAt(0)
+        if err == nil {
+                _, b, _, err2 := h.lineAt(next)
+                if err2 == nil && b != "" && b < a {
+                        err = fmt.Errorf("%s is not ordered by hash", fn)
+                }
+        }
+        if err != nil {
+                f.Close()
+                return nil, err
+        }
+        return h, nil
+}
+
+func (h *hibpFile) Close() error {
+        return h.f.Close()
+}
+
+//lineAt reads first line starting at or after offset, returns its start,
+//trimmed content and offset of the next line, empty line at the end of file
+func (h *hibpFile) lineAt(off int64) (int64, string, int64, error) {
+        start := off
+        if off > 0 {
+                start = off - 1
+        }
+        rd := bufio.NewReader(io.NewSectionReader(h.f, start, h.size - start))
+        if off > 0 {
+                //skip rest of the line containing off - 1
+                skip, err := rd.ReadString('\n')
+                if err == io.EOF {
+                        return h.size, "", h.size, nil
+                }
+                if err != nil {
+                        return 0, "", 0, err
+                }
+                start += int64(len(skip))
+        }
+        l, err := rd.ReadString('\n')
+        if err != nil && err != io.EOF {
+                return 0, "", 0, err
+        }
+        return start, strings.TrimSpace(l), start + int64(len(l)), nil
+}
+
+//count returns number of times password was seen in breaches
+func (h *hibpFile) count(p []byte) (int, error) {
+        sum := sha1.Sum(p)
+        target := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+        lo, hi := int64(0), h.size
+        for lo < hi {
+                mid := lo + (hi - lo) / 2
+                start, l, next, err := h.lineAt(mid)
+                if err != nil {
+                        return 0, err
+                }
+                if start >= hi || l == "" {
+                        hi = mid
+                        continue
+                }
+                if len(l) < hibpLine {
+                        return 0, fmt.Errorf("invalid line at %d", start)
+                }
+                k := strings.ToUpper(l[:hibpLine])
+                switch {
+                case k < target:
+                        lo = next
+                case k > target:
+                        hi = mid
+                default:
+                        //count part is optional, some mirrors have hashes only
+                        c := 1
+                        if i := strings.IndexByte(l, ':'); i >= 0 {
+                                c, err = strconv.Atoi(strings.TrimSpace(l[i + 1:]))
+                                if err != nil {
+                                        return 0, fmt.Errorf("invalid line at %d", start)
+                                }
+                        }
+                        return c, nil
+                }
+        }
+        return 0, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+        "io/ioutil"
+        "sort"
+        "strconv"
+        "strings"
+        "testing"
+        "crypto/sha1"
+        "encoding/hex"
+        "path/filepath"
+)
+
+func hibpHash(p string) string {
+        sum := sha1.Sum([]byte(p))
+        return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestHIBP(t *testing.T) {
+        seen := map[string]int{"password": 9545824, "123456": 37359195, "qwerty": 10, "letmein": 7, "dragon": 1}
+        var lines []string
+        for p, c := range seen {
+                lines = append(lines, hibpHash(p) + ":" + strconv.Itoa(c))
+        }
+        //hash without count, as some mirrors have it
+        lines = append(lines, hibpHash("monkey"))
+        sort.Strings(lines)
+
+        fn := filepath.Join(t.TempDir(), "pwned.txt")
+        if err := ioutil.WriteFile(fn, []byte(strings.Join(lines, "\r\n") + "\r\n"), 0600); err != nil {
+                t.Fatal(err)
+        }
+        h, err := openHIBP(fn)
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer h.Close()
+
+        seen["monkey"] = 1
+        seen["correct horse battery staple"] = 0
+        seen[""] = 0
+        for p, want := range seen {
+                c, err := h.count([]byte(p))
+                if err != nil || c != want {
+                        t.Errorf("%q: got %d %v, want %d", p, c, err, want)
+                }
+        }
+
+        //file ordered by prevalence is rejected
+        sort.Sort(sort.Reverse(sort.StringSlice(lines)))
+        if err := ioutil.WriteFile(fn, []byte(strings.Join(lines, "\n")), 0600); err != nil {
+                t.Fatal(err)
+        }
+        if h, err := openHIBP(fn); err == nil {
+                h.Close()
+                t.Error("unordered file is accepted")
+        }
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+        "fmt"
+        "time"
+        "bufio"
+        "strconv"
+        "encoding/base64"
+)
+
+const maxHistory = 10 //previous passwords kept per record
+
+//setPass replaces password keeping the old one in history
+func (v *Record) setPass(p string) {
+        if v.pass != "" && v.pass != p {
+                h := PassEntry{v.pass, time.Now()}
+                v.history = append([]PassEntry{h}, v.history...)
+                if len(v.history) > maxHistory {
+                        v.history = v.history[:maxHistory]
+                }
+        }
+        v.pass = p
+}
+
+type jsonPassEntry struct {
+        Entry    int    `json:"entry"`
+        Replaced string `json:"replaced"`
+        Password string `json:"password,omitempty"`
+}
+
+func passHistory(r *bufio.Reader, a *Args) {
+        if jsonOutput() {
+                v, err := findRecord(a.next(r, "Nickname"))
+                if err != nil {
+                        fmt.Printf("Error %s\n", err)
+                        return
+                }
+                out := []jsonPassEntry{}
+                for i, h := range v.history {
+                        e := jsonPassEntry{Entry: i + 1, Replaced: exportTime(h.replaced)}
+                        if outputSecrets {
+                                p, _ := base64.StdEncoding.DecodeString(h.pass)
+                                e.Password = string(p)
+                        }
+                        out = append(out, e)
+                }
+                printJSON(map[string]interface{}{"path": v.path(), "history": out})
+                return
+        }
+        v, err := historyRecord(r, a)
+        if err != nil {
+                return
+        }
+
+        for i, h := range v.history {
+                fmt.Printf("%d:\t%s\treplaced: %s\n", i + 1, secretMask, formatTime(h.replaced))
+        }
+
+        i, err := historyEntry(a.opt(r, "Entry to paste (empty to skip)"), v)
+        if err != nil || i < 0 {
+                return
+        }
+        p, err := base64.StdEncoding.DecodeString(v.history[i].pass)
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+        pasteSecret("Previous password", p)
+}
+
+func passRestore(r *bufio.Reader, a *Args) {
+        v, err := historyRecord(r, a)
+        if err != nil {
+                return
+        }
+
+        for i, h := range v.history {
+                fmt.Printf("%d:\t%s\treplaced: %s\n", i + 1, secretMask, formatTime(h.replaced))
+        }
+
+        i, err := historyEntry(a.next(r, "Entry to restore"), v)
+        if err != nil || i < 0 {
+                return
+        }
+
+        //current password goes to history, so restore can be undone
+        p := v.history[i].pass
+        v.history = append(v.history[:i], v.history[i + 1:]...)
+        v.setPass(p)
+        v.touch(true)
+        fmt.Printf("Password of %s restored\n", v.path())
+}
+
+func historyRecord(r *bufio.Reader, a *Args) (*Record, error) {
+        v, err := findRecord(a.next(r, "Nickname"))
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return nil, err
+        }
+        if len(v.history) == 0 {
+                fmt.Printf("No previous passwords for %s\n", v.path())
+                return nil, fmt.Errorf("No history")
+        }
+        return v, nil
+}
+
+//historyEntry parses entry number and returns its index, -1 on empty input
+func historyEntry(t string, v *Record) (int, error) {
+        if t == "" {
+                return -1, nil
+        }
+        i, err := strconv.Atoi(t)
+        if err != nil || i < 1 || i > len(v.history) {
+                fmt.Printf("Invalid entry: %s\n", t)
+                return -1, fmt.Errorf("Invalid entry")
+        }
+        return i - 1, nil
+}
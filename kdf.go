@@ -0,0 +1,161 @@
+package main
+
+import (
+        "fmt"
+        "bytes"
+        "crypto/rand"
+        "crypto/subtle"
+        "golang.org/x/crypto/scrypt"
+)
+
+//Vault key is derived from pass phrase with scrypt, its salt and cost are
+//kept in file header, so every file tells how to get its key:
+//  "PASS3\n" salt logN r p IV ciphertext
+//Older files ("PASS2\n" IV ciphertext, or no header at all) are encrypted
+//with sha256 of pass phrase, they are read with it and get new key on save
+const kdfMagic = "PASS3\n"
+const kdfSaltSize = 16
+const kdfHeaderSize = kdfSaltSize + 3
+
+//kdfCost is log2 of scrypt N for new keys, see kdf.cost setting
+var kdfCost = 15
+
+//limits of kdf.cost, files asking for more are rejected as damaged
+const kdfMinCost = 10
+const kdfMaxCost = 20
+
+type kdfParams struct {
+        salt []byte
+        logN byte
+        r, p byte
+}
+
+//vaultKey holds keys derived from pass phrase of a database file
+type vaultKey struct {
+        kdf *kdfParams
+        key []byte
+        old []byte //sha256 key of files written before kdf
+        iv  []byte //IV of files without header
+}
+
+func newKDF() (*kdfParams, error) {
+        k := &kdfParams{salt: make([]byte, kdfSaltSize), logN: byte(kdfCost), r: 8, p: 1}
+        if _, err := rand.Read(k.salt); err != nil {
+                return nil, err
+        }
+        return k, nil
+}
+
+func (k *kdfParams) header() []byte {
+        h := append([]byte(kdfMagic), k.salt...)
+        return append(h, k.logN, k.r, k.p)
+}
+
+func (k *kdfParams) equal(o *kdfParams) bool {
+        return k != nil && o != nil && bytes.Equal(k.salt, o.salt) &&
+               k.logN == o.logN && k.r == o.r && k.p == o.p
+}
+
+//fileKDF returns kdf parameters from file header, nil for older files
+func fileKDF(data []byte) (*kdfParams, error) {
+        if !bytes.HasPrefix(data, []byte(kdfMagic)) {
+                return nil, nil
+        }
+        h := data[len(kdfMagic):]
+        if len(h) < kdfHeaderSize {
+                return nil, wrongPassf("Invalid file format")
+        }
+        k := &kdfParams{salt: h[:kdfSaltSize], logN: h[kdfSaltSize], r: h[kdfSaltSize + 1], p: h[kdfSaltSize + 2]}
+        if k.logN < kdfMinCost || k.logN > kdfMaxCost || k.r == 0 || k.r > 32 || k.p == 0 || k.p > 16 {
+                return nil, wrongPassf("Invalid file format")
+        }
+        return k, nil
+}
+
+//deriveKey makes key of pass phrase with given kdf, nil kdf gets new salt
+func deriveKey(pass []byte, kdf *kdfParams) (*vaultKey, error) {
+        var err error
+        if kdf == nil {
+                if kdf, err = newKDF(); err != nil {
+                        return nil, err
+                }
+        }
+        key, err := scrypt.Key(pass, kdf.salt, 1 << kdf.logN, int(kdf.r), int(kdf.p), 32)
+        if err != nil {
+                return nil, err
+        }
+        old, iv := passToKey(pass)
+        return &vaultKey{kdf: kdf, key: key, old: old, iv: iv}, nil
+}
+
+//fileKey derives key for the file as given by its header
+func fileKey(pass, data []byte) (*vaultKey, error) {
+        kdf, err := fileKDF(data)
+        if err != nil {
+                return nil, err
+        }
+        return deriveKey(pass, kdf)
+}
+
+//matches reports if pass phrase gives this key
+func (k *vaultKey) matches(pass []byte) bool {
+        n, err := deriveKey(pass, k.kdf)
+        return err == nil && subtle.ConstantTimeCompare(n.key, k.key) == 1
+}
+
+func (k *vaultKey) wipe() {
+        for _, b := range [][]byte{k.key, k.old} {
+                for i := range b {
+                        b[i] = 0
+                }
+        }
+}
+
+//keyOf returns key the file was encrypted with among keys of database,
+//file written by another copy has its own salt and needs askKey
+func (d *Database) keyOf(data []byte) *vaultKey {
+        kdf, _ := fileKDF(data)
+        if kdf == nil {
+                return d.key
+        }
+        for _, k := range append([]*vaultKey{d.key}, d.others...) {
+                if k.kdf.equal(kdf) {
+                        return k
+                }
+        }
+        return d.key
+}
+
+//askKey is keyOf, which asks pass phrase of the copy the file comes from
+//if its key is not known yet
+func (d *Database) askKey(data []byte) (*vaultKey, error) {
+        kdf, err := fileKDF(data)
+        if err != nil {
+                return nil, err
+        }
+        k := d.keyOf(data)
+        if kdf == nil || k.kdf.equal(kdf) {
+                return k, nil
+        }
+
+        fmt.Print("File is saved by other copy, its Pass phrase> ")
+        p, _ := readPassword()
+        //Hack to clear cursor after password read
+        fmt.Print("\rFile is saved by other copy, its Pass phrase>                                      \r\n")
+        if len(p) == 0 {
+                return nil, wrongPassf("Empty pass phrase")
+        }
+        k, err = deriveKey(p, kdf)
+        for i := range p {
+                p[i] = 0
+        }
+        if err != nil {
+                return nil, err
+        }
+        //wrong pass phrase must not stick
+        if _, _, err = decodeVault(data, k); err != nil {
+                return nil, err
+        }
+        d.others = append(d.others, k)
+        return k, nil
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+        "os"
+        "fmt"
+        "sort"
+        "time"
+        "bufio"
+        "strings"
+        "io/ioutil"
+        "encoding/json"
+)
+
+//Three-way merge of two copies of the database. Records are matched by id,
+//records of older databases without id are matched by path. Base is the last
+//common version of both copies: every successful save keeps previous file
+//as <file>.bak and saving merged database keeps it as <file>.base
+const backupExt = ".bak"
+const baseExt = ".base"
+
+//mergeChoice resolves conflict, returns true to keep local value
+//field is empty when record is deleted on one side and modified on another
+type mergeChoice func(path, field, local, other string) bool
+
+type mergeStats struct {
+        added     int
+        updated   int
+        deleted   int
+        conflicts int
+        renamed   int
+}
+
+//mergeField is a record field merged as a single value
+type mergeField struct {
+        name   string
+        get    func(v *Record) string
+        set    func(v *Record, s string)
+        secret bool
+}
+
+var mergeFields = []mergeField{
+        {"path", func(v *Record) string { return v.path() },
+                 func(v *Record, s string) { v.folder, v.nick = splitPath(s) }, false},
+        {"login", func(v *Record) string { return v.login },
+                  func(v *Record, s string) { v.login = s }, false},
+        {"hint", func(v *Record) string { return v.hint },
+                 func(v *Record, s string) { v.hint = s }, false},
+        {"pass", func(v *Record) string { return v.pass },
+                 func(v *Record, s string) { v.pass = s }, true},
+        {"urls", func(v *Record) string { return strings.Join(v.urls, " ") },
+                 func(v *Record, s string) { v.urls = strings.Fields(s) }, false},
+        {"tags", func(v *Record) string { return strings.Join(v.tags, ", ") },
+                 func(v *Record, s string) { v.tags = splitTags(s) }, false},
+        {"notes", func(v *Record) string { return v.notes },
+                  func(v *Record, s string) { v.notes = s }, false},
+        {"fields", getFields, setFields, false},
+        {"otp", func(v *Record) string { return v.otp },
+                func(v *Record, s string) { v.otp = s }, true},
+        {"sshkey", func(v *Record) string { return v.sshkey },
+                   func(v *Record, s string) { v.sshkey = s }, true},
+}
+
+func getFields(v *Record) string {
+        var f []storedField
+        for _, e := range v.fields {
+                f = append(f, storedField{e.name, e.value, e.secret})
+        }
+        out, _ := json.Marshal(f)
+        return string(out)
+}
+
+func setFields(v *Record, s string) {
+        var f []storedField
+        json.Unmarshal([]byte(s), &f)
+        v.fields = nil
+        for _, e := range f {
+                v.fields = append(v.fields, Field{e.Name, e.Value, e.Secret})
+        }
+}
+
+//splitPath splits absolute record path into folder and nickname
+func splitPath(p string) (string, string) {
+        if i := strings.LastIndex(p, "/"); i >= 0 {
+                return p[:i], p[i + 1:]
+        }
+        return "", p
+}
+
+//sameRecord reports if two records are versions of the same record
+func sameRecord(a, b *Record) bool {
+        if a.id != "" && b.id != "" {
+                return a.id == b.id
+        }
+        return a.path() == b.path()
+}
+
+func sameContent(a, b *Record) bool {
+        for _, f := range mergeFields {
+                if f.get(a) != f.get(b) {
+                        return false
+                }
+        }
+        return true
+}
+
+//mergeDisplay is shown to user when resolving conflict
+func mergeDisplay(f mergeField, v *Record) string {
+        if f.name == "fields" {
+                var out []string
+                for _, e := range v.fields {
+                        out = append(out, e.name + ": " + e.display())
+                }
+                return strings.Join(out, ", ")
+        }
+        if f.secret {
+                if f.get(v) == "" {
+                        return ""
+                }
+                return fmt.Sprintf("%s changed %s", secretMask, formatTime(v.changed))
+        }
+        return f.get(v)
+}
+
+//mergeRecords merges local and other versions of the database with base
+//as their common ancestor, base may be empty
+func mergeRecords(base, local, other []Record, choose mergeChoice) ([]Record, mergeStats) {
+        type triple struct {
+                b, l, o *Record
+        }
+        var st mergeStats
+        var ts []*triple
+        for i := range local {
+                ts = append(ts, &triple{l: &local[i]})
+        }
+        for i := range other {
+                o := &other[i]
+                matched := false
+                for _, t := range ts {
+                        if t.o == nil && sameRecord(t.l, o) {
+                                t.o = o
+                                matched = true
+                                break
+                        }
+                }
+                if !matched {
+                        ts = append(ts, &triple{o: o})
+                }
+        }
+        //base records matching nothing are deleted on both sides
+        for i := range base {
+                b := &base[i]
+                for _, t := range ts {
+                        if t.b == nil && ((t.l != nil && sameRecord(t.l, b)) || (t.o != nil && sameRecord(t.o, b))) {
+                                t.b = b
+                                break
+                        }
+                }
+        }
+
+        var out []Record
+        for _, t := range ts {
+                switch {
+                case t.l != nil && t.o != nil:
+                        r := mergeRecord(t.b, t.l, t.o, choose, &st)
+                        if !sameContent(&r, t.l) {
+                                st.updated++
+                        }
+                        out = append(out, r)
+                case t.l != nil:
+                        //missing in other: added locally or deleted there
+                        if t.b == nil {
+                                out = append(out, t.l.clone())
+                        } else if sameContent(t.l, t.b) {
+                                st.deleted++
+                        } else {
+                                st.conflicts++
+                                if choose(t.l.path(), "", "modified", "deleted") {
+                                        out = append(out, t.l.clone())
+                                } else {
+                                        st.deleted++
+                                }
+                        }
+                default:
+                        //missing locally: added in other or deleted here
+                        if t.b == nil {
+                                out = append(out, t.o.clone())
+                                st.added++
+                        } else if !sameContent(t.o, t.b) {
+                                st.conflicts++
+                                if !choose(t.o.path(), "", "deleted", "modified") {
+                                        out = append(out, t.o.clone())
+                                        st.added++
+                                }
+                        }
+                }
+        }
+
+        st.renamed = uniquePaths(out)
+        return out, st
+}
+
+//mergeRecord merges two versions of the record field by field
+func mergeRecord(b, l, o *Record, choose mergeChoice, st *mergeStats) Record {
+        r := l.clone()
+        if r.id == "" {
+                r.id = o.id
+        }
+        passOther := false
+        for _, f := range mergeFields {
+                lv, ov := f.get(l), f.get(o)
+                if lv == ov {
+                        continue
+                }
+                take := ov
+                switch {
+                case b != nil && lv == f.get(b):
+                        //changed in other only
+                case b != nil && ov == f.get(b):
+                        take = lv
+                default:
+                        st.conflicts++
+                        if choose(l.path(), f.name, mergeDisplay(f, l), mergeDisplay(f, o)) {
+                                take = lv
+                        }
+                }
+                f.set(&r, take)
+                if f.name == "pass" && take == ov {
+                        passOther = true
+                }
+        }
+
+        if passOther {
+                r.changed = o.changed
+        }
+        if r.created.IsZero() || (!o.created.IsZero() && o.created.Before(r.created)) {
+                r.created = o.created
+        }
+        if o.modified.After(r.modified) {
+                r.modified = o.modified
+        }
+        r.history = mergeHistory(l.history, o.history)
+        //password which lost goes to history like setPass does,
+        //unless it is already there as replaced version
+        if lost := l.pass; l.pass != o.pass {
+                if r.pass == l.pass {
+                        lost = o.pass
+                }
+                if lost != "" && !inHistory(r.history, lost) {
+                        r.history = append([]PassEntry{{lost, time.Now()}}, r.history...)
+                        if len(r.history) > maxHistory {
+                                r.history = r.history[:maxHistory]
+                        }
+                }
+        }
+        return r
+}
+
+func inHistory(h []PassEntry, p string) bool {
+        for _, e := range h {
+                if e.pass == p {
+                        return true
+                }
+        }
+        return false
+}
+
+//mergeHistory joins previous passwords of both versions, most recent first
+func mergeHistory(a, b []PassEntry) []PassEntry {
+        seen := map[string]bool{}
+        var out []PassEntry
+        for _, h := range append(append([]PassEntry(nil), a...), b...) {
+                k := fmt.Sprintf("%s:%d", h.pass, toUnix(h.replaced))
+                if !seen[k] {
+                        seen[k] = true
+                        out = append(out, h)
+                }
+        }
+        sort.SliceStable(out, func(i, j int) bool {
+                return out[i].replaced.After(out[j].replaced)
+        })
+        if len(out) > maxHistory {
+                out = out[:maxHistory]
+        }
+        return out
+}
+
+//uniquePaths renames records which ended up with the same path,
+//like records added on both sides under the same nickname
+func uniquePaths(records []Record) int {
+        renamed := 0
+        seen := map[string]bool{}
+        for i := range records {
+                v := &records[i]
+                if !seen[v.path()] {
+                        seen[v.path()] = true
+                        continue
+                }
+                nick := v.nick
+                for n := 2; seen[v.path()]; n++ {
+                        v.nick = fmt.Sprintf("%s-%d", nick, n)
+                }
+                seen[v.path()] = true
+                renamed++
+        }
+        return renamed
+}
+
+//promptChoice asks user to resolve merge conflicts
+func promptChoice(r *bufio.Reader) mergeChoice {
+        return func(path, field, local, other string) bool {
+                if field == "" {
+                        fmt.Printf("Conflict in [%s]: %s locally, %s in other copy\n", path, local, other)
+                } else {
+                        fmt.Printf("Conflict in [%s] %s\n", path, field)
+                }
+                fmt.Printf("\tlocal: %s\n", local)
+                fmt.Printf("\tother: %s\n", other)
+                for {
+                        fmt.Print("Keep (l)ocal or (o)ther [l]> ")
+                        c, _ := r.ReadString('\n')
+                        switch strings.ToLower(strings.TrimSpace(c)) {
+                        case "", "l":
+                                return true
+                        case "o":
+                                return false
+                        }
+                }
+        }
+}
+
+func passMerge(r *bufio.Reader, a *Args) {
+        if db.key == nil {
+                fmt.Println("No active database")
+                return
+        }
+
+        fn, err := mustPath(r, a, promptRetries)
+        if err != nil {
+                return
+        }
+        data, err := ioutil.ReadFile(fn)
+        if err != nil {
+                fmt.Printf("Error reading file %s\n", err)
+                return
+        }
+
+        fmt.Print("Pass phrase of other copy (empty if the same)> ")
+        p, _ := readPassword()
+        //Hack to clear cursor after password read
+        fmt.Print("\rPass phrase of other copy (empty if the same)>                                      \r\n")
+        var key *vaultKey
+        if len(p) != 0 {
+                key, err = fileKey(p, data)
+        } else {
+                key, err = db.askKey(data)
+        }
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+        other, _, err := decodeVault(data, key)
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+
+        base, err := readMergeBase(r, a)
+        if err != nil {
+                fmt.Printf("Error reading base %s\n", err)
+                return
+        }
+
+        merged, st := mergeRecords(base, db.records, other, promptChoice(r))
+        db.records = merged
+        db.newBase = true
+        printMergeStats(st)
+        fmt.Println("Use save to write merged database")
+}
+
+func printMergeStats(st mergeStats) {
+        fmt.Printf("Merged: %d added, %d updated, %d deleted, %d conflicts resolved\n",
+                   st.added, st.updated, st.deleted, st.conflicts)
+        if st.renamed > 0 {
+                fmt.Printf("%d records renamed to keep nicknames unique\n", st.renamed)
+        }
+}
+
+//readMergeBase asks for base file, which is encrypted with our own pass phrase.
+//Only merge base is offered by default, backup is the previous local save,
+//not the state both copies shared, records added before it would be deleted
+func readMergeBase(r *bufio.Reader, a *Args) ([]Record, error) {
+        def := ""
+        if _, err := os.Stat(db.filename + baseExt); db.filename != "" && err == nil {
+                def = db.filename + baseExt
+        }
+        fn := expandHome(a.opt(r, fmt.Sprintf("Base file, '-' for none [%s]", def)))
+        if fn == "" {
+                fn = def
+        }
+        if fn == "" || fn == "-" {
+                fmt.Println("No base, every difference is a conflict")
+                return nil, nil
+        }
+
+        data, err := ioutil.ReadFile(fn)
+        if err != nil {
+                return nil, err
+        }
+        base, _, err := decodeVault(data, db.keyOf(data))
+        return base, err
+}
+
+//saveMergeBase keeps just saved merged database as base for the next merge
+func saveMergeBase(d *Database, data []byte) {
+        if err := ioutil.WriteFile(d.filename + baseExt, data, 0600); err != nil {
+                fmt.Printf("Error writing merge base %s\n", err)
+                return
+        }
+        d.newBase = false
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+        "testing"
+)
+
+func findPath(recs []Record, p string) *Record {
+        for i := range recs {
+                if recs[i].path() == p {
+                        return &recs[i]
+                }
+        }
+        return nil
+}
+
+func TestMergeRecords(t *testing.T) {
+        a := Record{id: "a", nick: "a", login: "alice"}
+        r := Record{id: "r", nick: "r", login: "bob"}
+        c := Record{id: "c", nick: "c", login: "carol"}
+        c2 := c
+        c2.login = "carl"
+
+        tests := []struct {
+                name      string
+                base      []Record
+                local     []Record
+                other     []Record
+                keep      []string //paths expected in result
+                gone      []string //paths expected to be removed
+                deleted   int
+                conflicts int
+        }{
+                //backup of the previous local save already has r, other copy
+                //never had it, so with no shared base r is added locally
+                {"no base keeps local record", nil, []Record{a, r}, []Record{a}, []string{"a", "r"}, nil, 0, 0},
+                {"no base keeps other record", nil, []Record{a}, []Record{a, r}, []string{"a", "r"}, nil, 0, 0},
+                {"shared base deletes record", []Record{a, r}, []Record{a, r}, []Record{a}, []string{"a"}, []string{"r"}, 1, 0},
+                {"deleted here", []Record{a, r}, []Record{a}, []Record{a, r}, []string{"a"}, []string{"r"}, 0, 0},
+                {"no base makes difference conflict", nil, []Record{c}, []Record{c2}, []string{"c"}, nil, 0, 1},
+                {"base picks changed side", []Record{c}, []Record{c}, []Record{c2}, []string{"c"}, nil, 0, 0},
+        }
+        for _, tc := range tests {
+                asked := 0
+                out, st := mergeRecords(tc.base, tc.local, tc.other, func(path, field, l, o string) bool {
+                        asked++
+                        return true
+                })
+                for _, p := range tc.keep {
+                        if findPath(out, p) == nil {
+                                t.Errorf("%s: %s is missing", tc.name, p)
+                        }
+                }
+                for _, p := range tc.gone {
+                        if findPath(out, p) != nil {
+                                t.Errorf("%s: %s is not deleted", tc.name, p)
+                        }
+                }
+                if st.deleted != tc.deleted || st.conflicts != tc.conflicts || asked != tc.conflicts {
+                        t.Errorf("%s: deleted %d conflicts %d asked %d", tc.name, st.deleted, st.conflicts, asked)
+                }
+        }
+
+        //no base: login conflict resolved as local, base: other change is taken
+        out, _ := mergeRecords(nil, []Record{c}, []Record{c2}, func(path, field, l, o string) bool { return true })
+        if v := findPath(out, "c"); v == nil || v.login != "carol" {
+                t.Errorf("local choice is not kept")
+        }
+        out, _ = mergeRecords([]Record{c}, []Record{c}, []Record{c2}, func(path, field, l, o string) bool { return true })
+        if v := findPath(out, "c"); v == nil || v.login != "carl" {
+                t.Errorf("change of other copy is not taken")
+        }
+
+        //password conflict: losing password is kept in history
+        p1 := Record{id: "p", nick: "p", pass: "bG9jYWw="}
+        p2 := p1
+        p2.pass = "b3RoZXI="
+        for _, local := range []bool{true, false} {
+                out, _ = mergeRecords(nil, []Record{p1}, []Record{p2}, func(path, field, l, o string) bool { return local })
+                v := findPath(out, "p")
+                win, lost := p2.pass, p1.pass
+                if local {
+                        win, lost = lost, win
+                }
+                if v == nil || v.pass != win || len(v.history) != 1 || v.history[0].pass != lost {
+                        t.Errorf("local %v: losing password is not in history %+v", local, v)
+                }
+        }
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+        "fmt"
+        "hash"
+        "time"
+        "bufio"
+        "bytes"
+        "strconv"
+        "strings"
+        "net/url"
+        "crypto/hmac"
+        "crypto/sha1"
+        "crypto/sha256"
+        "crypto/sha512"
+        "encoding/binary"
+        "encoding/base32"
+        "encoding/base64"
+)
+
+//otpParams is parsed otpauth:// URI, see
+//https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+type otpParams struct {
+        kind    string //totp or hotp
+        label   string
+        issuer  string
+        secret  []byte
+        algo    string //SHA1, SHA256 or SHA512
+        digits  int
+        period  int    //totp only, seconds
+        counter uint64 //hotp only
+}
+
+const otpScheme = "otpauth://"
+const otpMigrationScheme = "otpauth-migration://"
+
+var otpB32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+//parseOTP accepts otpauth:// URI or bare base32 secret, which is treated as
+//default TOTP (SHA1, 6 digits, 30 seconds)
+func parseOTP(s string) (*otpParams, error) {
+        s = strings.TrimSpace(s)
+        o := &otpParams{kind: "totp", algo: "SHA1", digits: 6, period: 30}
+        if !strings.HasPrefix(strings.ToLower(s), otpScheme) {
+                k, err := decodeB32(s)
+                if err != nil {
+                        return nil, err
+                }
+                o.secret = k
+                return o, nil
+        }
+
+        u, err := url.Parse(s)
+        if err != nil {
+                return nil, err
+        }
+        o.kind = strings.ToLower(u.Host)
+        if o.kind != "totp" && o.kind != "hotp" {
+                return nil, fmt.Errorf("unknown otp type %s", u.Host)
+        }
+        o.label = strings.TrimPrefix(u.Path, "/")
+
+        q := u.Query()
+        o.secret, err = decodeB32(q.Get("secret"))
+        if err != nil {
+                return nil, err
+        }
+        o.issuer = q.Get("issuer")
+        if a := q.Get("algorithm"); a != "" {
+                o.algo = strings.ToUpper(a)
+        }
+        if d := q.Get("digits"); d != "" {
+                if o.digits, err = strconv.Atoi(d); err != nil {
+                        return nil, fmt.Errorf("invalid digits %s", d)
+                }
+        }
+        if p := q.Get("period"); p != "" {
+                if o.period, err = strconv.Atoi(p); err != nil || o.period <= 0 {
+                        return nil, fmt.Errorf("invalid period %s", p)
+                }
+        }
+        if c := q.Get("counter"); c != "" {
+                if o.counter, err = strconv.ParseUint(c, 10, 64); err != nil {
+                        return nil, fmt.Errorf("invalid counter %s", c)
+                }
+        }
+        return o, o.validate()
+}
+
+func decodeB32(s string) ([]byte, error) {
+        s = strings.ToUpper(strings.ReplaceAll(s, " ", ""))
+        s = strings.TrimRight(s, "=")
+        if s == "" {
+                return nil, fmt.Errorf("otp secret is empty")
+        }
+        k, err := otpB32.DecodeString(s)
+        if err != nil {
+                return nil, fmt.Errorf("invalid base32 secret")
+        }
+        return k, nil
+}
+
+func (o *otpParams) validate() error {
+        if o.digits < 6 || o.digits > 8 {
+                return fmt.Errorf("otp digits must be 6 to 8")
+        }
+        if o.hash() == nil {
+                return fmt.Errorf("unsupported otp algorithm %s", o.algo)
+        }
+        return nil
+}
+
+func (o *otpParams) hash() func() hash.Hash {
+        switch o.algo {
+        case "SHA1":
+                return sha1.New
+        case "SHA256":
+                return sha256.New
+        case "SHA512":
+                return sha512.New
+        }
+        return nil
+}
+
+func (o *otpParams) uri() string {
+        q := url.Values{}
+        q.Set("secret", otpB32.EncodeToString(o.secret))
+        if o.issuer != "" {
+                q.Set("issuer", o.issuer)
+        }
+        q.Set("algorithm", o.algo)
+        q.Set("digits", strconv.Itoa(o.digits))
+        if o.kind == "totp" {
+                q.Set("period", strconv.Itoa(o.period))
+        } else {
+                q.Set("counter", strconv.FormatUint(o.counter, 10))
+        }
+        u := url.URL{Scheme: "otpauth", Host: o.kind, Path: "/" + o.label, RawQuery: q.Encode()}
+        return u.String()
+}
+
+//code returns current code and number of seconds it stays valid,
+//hotp codes are valid until used, so remaining is 0
+func (o *otpParams) code(t time.Time) (string, int) {
+        if o.kind == "hotp" {
+                return hotp(o.secret, o.counter, o.digits, o.hash()), 0
+        }
+        step := uint64(t.Unix()) / uint64(o.period)
+        rem := o.period - int(uint64(t.Unix()) % uint64(o.period))
+        return hotp(o.secret, step, o.digits, o.hash()), rem
+}
+
+//hotp implements RFC 4226 dynamic truncation, RFC 6238 uses it with time step counter
+func hotp(key []byte, counter uint64, digits int, h func() hash.Hash) string {
+        var msg [8]byte
+        binary.BigEndian.PutUint64(msg[:], counter)
+        m := hmac.New(h, key)
+        m.Write(msg[:])
+        sum := m.Sum(nil)
+
+        off := sum[len(sum) - 1] & 0x0f
+        v := binary.BigEndian.Uint32(sum[off:off + 4]) & 0x7fffffff
+        mod := uint32(1)
+        for i := 0; i < digits; i++ {
+                mod *= 10
+        }
+        return fmt.Sprintf("%0*d", digits, v % mod)
+}
+
+//otpCode computes current code of the record, hotp counter is advanced
+func (v *Record) otpCode() (string, int, error) {
+        if v.otp == "" {
+                return "", 0, fmt.Errorf("No otp secret in %s", v.path())
+        }
+        o, err := parseOTP(v.otp)
+        if err != nil {
+                return "", 0, err
+        }
+        c, rem := o.code(time.Now())
+        if o.kind == "hotp" {
+                o.counter++
+                v.otp = o.uri()
+                v.touch(false)
+        }
+        return c, rem, nil
+}
+
+func passOtp(r *bufio.Reader, a *Args) {
+        v, err := findRecord(a.next(r, "Nickname"))
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+
+        c, rem, err := v.otpCode()
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+        if jsonOutput() {
+                //counter based codes have no time limit, valid is 0 for them
+                printJSON(map[string]interface{}{"path": v.path(), "code": c, "valid": rem})
+                return
+        }
+        if rem > 0 {
+                fmt.Printf("%s\t(valid for %ds)\n", c, rem)
+        } else {
+                fmt.Println(c)
+        }
+
+        //otp github --paste
+        if a.has("paste") {
+                pasteSecret("Code", []byte(c))
+                return
+        }
+        if a.inline() {
+                return
+        }
+        fmt.Print("Paste into clipboard (y/N)> ")
+        y, _ := r.ReadString('\n')
+        if strings.ToLower(strings.TrimSpace(y)) == "y" {
+                pasteSecret("Code", []byte(c))
+        }
+}
+
+//readOTP asks for otp secret, returns normalized otpauth URI or empty string
+func readOTP(v *Record) (string, error) {
+        fmt.Print("OTP secret or otpauth:// URI (optional)> ")
+        p, _ := readPassword()
+        //Hack to clear cursor after password read
+        fmt.Print("\rOTP secret or otpauth:// URI (optional)>                                      \r\n")
+        if len(p) == 0 {
+                return "", nil
+        }
+        o, err := parseOTP(string(p))
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return "", err
+        }
+        if o.label == "" {
+                o.label = v.nick
+        }
+        return o.uri(), nil
+}
+
+//passOtpImport reads otpauth:// and Google Authenticator otpauth-migration:// URIs
+//and attaches them to existing records or creates new ones
+//URIs given as arguments are imported under default nicknames
+func passOtpImport(r *bufio.Reader, a *Args) {
+        uris := a.pos
+        if len(uris) == 0 {
+                fmt.Println("Enter otpauth URIs, finish with empty line")
+        }
+        var all []*otpParams
+        for i := 0; ; i++ {
+                var l string
+                var err error
+                if len(a.pos) > 0 {
+                        if i == len(uris) {
+                                break
+                        }
+                        l = uris[i]
+                } else {
+                        fmt.Print("URI> ")
+                        l, _ = r.ReadString('\n')
+                        if l = strings.TrimSpace(l); l == "" {
+                                break
+                        }
+                }
+                var p []*otpParams
+                if strings.HasPrefix(strings.ToLower(l), otpMigrationScheme) {
+                        p, err = parseMigration(l)
+                } else {
+                        var o *otpParams
+                        o, err = parseOTP(l)
+                        p = append(p, o)
+                }
+                if err != nil {
+                        fmt.Printf("Error %s\n", err)
+                        continue
+                }
+                all = append(all, p...)
+        }
+
+        count := 0
+        for _, o := range all {
+                issuer, account := o.issuer, o.label
+                if i := strings.Index(account, ":"); i >= 0 {
+                        if issuer == "" {
+                                issuer = account[:i]
+                        }
+                        account = strings.TrimSpace(account[i + 1:])
+                }
+                def := issuer
+                if def == "" {
+                        def = account
+                }
+
+                n := ""
+                if len(a.pos) == 0 {
+                        fmt.Printf("Nickname for %s [%s]> ", o.label, def)
+                        n, _ = r.ReadString('\n')
+                        n = strings.TrimSpace(n)
+                }
+                if n == "" {
+                        n = def
+                }
+                if n == "" {
+                        fmt.Println("Nickname can't be empty, skipped")
+                        continue
+                }
+
+                v, err := findRecord(n)
+                if err != nil {
+                        if strings.Contains(n, ":") {
+                                fmt.Println("Nickname can't contain ':', skipped")
+                                continue
+                        }
+                        f, nick := resolvePath(n)
+                        db.records = append(db.records, Record{id: newID(), folder: f, nick: nick, login: account, created: time.Now()})
+                        v = &db.records[len(db.records) - 1]
+                } else if v.otp != "" && v.otp != o.uri() && !replaceOTP(r, a, v) {
+                        fmt.Printf("%s already has otp secret, skipped\n", v.path())
+                        continue
+                }
+                v.otp = o.uri()
+                v.touch(false)
+                count++
+        }
+        fmt.Printf("Imported %d otp secrets\n", count)
+}
+
+//replaceOTP asks if existing otp secret of the record is replaced,
+//with inline arguments it is replaced only when --overwrite is given
+func replaceOTP(r *bufio.Reader, a *Args, v *Record) bool {
+        if a.inline() {
+                return a.has("overwrite")
+        }
+        fmt.Printf("Replace otp secret of %s (y/N)> ", v.path())
+        y, _ := r.ReadString('\n')
+        return strings.ToLower(strings.TrimSpace(y)) == "y"
+}
+
+//parseMigration decodes Google Authenticator export, which is base64 encoded
+//protobuf MigrationPayload message in "data" parameter
+func parseMigration(s string) ([]*otpParams, error) {
+        u, err := url.Parse(s)
+        if err != nil {
+                return nil, err
+        }
+        //'+' of base64 alphabet is turned into space by query decoding
+        d := strings.ReplaceAll(u.Query().Get("data"), " ", "+")
+        data, err := base64.StdEncoding.DecodeString(d)
+        if err != nil {
+                data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(d, "="))
+                if err != nil {
+                        return nil, fmt.Errorf("invalid migration data")
+                }
+        }
+
+        var out []*otpParams
+        err = protoFields(data, func(num int, val uint64, b []byte) error {
+                if num != 1 || b == nil { //otp_parameters
+                        return nil
+                }
+                o, err := parseMigrationOtp(b)
+                if err != nil {
+                        return err
+                }
+                out = append(out, o)
+                return nil
+        })
+        return out, err
+}
+
+func parseMigrationOtp(data []byte) (*otpParams, error) {
+        o := &otpParams{kind: "totp", algo: "SHA1", digits: 6, period: 30}
+        err := protoFields(data, func(num int, val uint64, b []byte) error {
+                switch num {
+                case 1:
+                        o.secret = append([]byte(nil), b...)
+                case 2:
+                        o.label = string(b)
+                case 3:
+                        o.issuer = string(b)
+                case 4:
+                        switch val {
+                        case 2:
+                                o.algo = "SHA256"
+                        case 3:
+                                o.algo = "SHA512"
+                        case 4:
+                                return fmt.Errorf("MD5 otp algorithm is not supported")
+                        }
+                case 5:
+                        if val == 2 {
+                                o.digits = 8
+                        }
+                case 6:
+                        if val == 1 {
+                                o.kind = "hotp"
+                        }
+                case 7:
+                        o.counter = val
+                }
+                return nil
+        })
+        if err != nil {
+                return nil, err
+        }
+        if len(o.secret) == 0 {
+                return nil, fmt.Errorf("otp secret is empty")
+        }
+        return o, o.validate()
+}
+
+//protoFields walks protobuf wire format calling f for every varint
+//and length-delimited field, other wire types are skipped
+func protoFields(data []byte, f func(num int, val uint64, b []byte) error) error {
+        rd := bytes.NewReader(data)
+        for rd.Len() > 0 {
+                tag, err := binary.ReadUvarint(rd)
+                if err != nil {
+                        return fmt.Errorf("invalid protobuf data")
+                }
+                num := int(tag >> 3)
+                switch tag & 7 {
+                case 0:
+                        v, err := binary.ReadUvarint(rd)
+                        if err != nil {
+                                return fmt.Errorf("invalid protobuf data")
+                        }
+                        err = f(num, v, nil)
+                        if err != nil {
+                                return err
+                        }
+                case 1:
+                        if _, err = rd.Seek(8, 1); err != nil {
+                                return err
+                        }
+                case 2:
+                        l, err := binary.ReadUvarint(rd)
+                        if err != nil || l > uint64(rd.Len()) {
+                                return fmt.Errorf("invalid protobuf data")
+                        }
+                        b := make([]byte, l)
+                        rd.Read(b)
+                        err = f(num, 0, b)
+                        if err != nil {
+                                return err
+                        }
+                case 5:
+                        if _, err = rd.Seek(4, 1); err != nil {
+                                return err
+                        }
+                default:
+                        return fmt.Errorf("invalid protobuf wire type")
+                }
+        }
+        return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+        "time"
+        "testing"
+)
+
+//RFC 6238 appendix B, keys are ASCII digits repeated to hash size
+func TestTOTP(t *testing.T) {
+        keys := map[string]string{
+                "SHA1":   "12345678901234567890",
+                "SHA256": "12345678901234567890123456789012",
+                "SHA512": "1234567890123456789012345678901234567890123456789012345678901234",
+        }
+        tests := []struct {
+                time int64
+                algo string
+                code string
+        }{
+                {59, "SHA1", "94287082"},
+                {59, "SHA256", "46119246"},
+                {59, "SHA512", "90693936"},
+                {1111111109, "SHA1", "07081804"},
+                {1111111109, "SHA256", "68084774"},
+                {1111111109, "SHA512", "25091201"},
+                {1111111111, "SHA1", "14050471"},
+                {1111111111, "SHA256", "67062674"},
+                {1111111111, "SHA512", "99943326"},
+                {1234567890, "SHA1", "89005924"},
+                {1234567890, "SHA256", "91819424"},
+                {1234567890, "SHA512", "93441116"},
+                {2000000000, "SHA1", "69279037"},
+                {2000000000, "SHA256", "90698825"},
+                {2000000000, "SHA512", "38618901"},
+                {20000000000, "SHA1", "65353130"},
+                {20000000000, "SHA256", "77737706"},
+                {20000000000, "SHA512", "47863826"},
+        }
+        for _, tc := range tests {
+                o := &otpParams{kind: "totp", algo: tc.algo, digits: 8, period: 30, secret: []byte(keys[tc.algo])}
+                c, rem := o.code(time.Unix(tc.time, 0))
+                if c != tc.code {
+                        t.Errorf("%d %s: got %s, want %s", tc.time, tc.algo, c, tc.code)
+                }
+                if want := 30 - int(tc.time % 30); rem != want {
+                        t.Errorf("%d %s: valid %d, want %d", tc.time, tc.algo, rem, want)
+                }
+        }
+}
+
+//RFC 4226 appendix D
+func TestHOTP(t *testing.T) {
+        codes := []string{"755224", "287082", "359152", "969429", "338314",
+                "254676", "287922", "162583", "399871", "520489"}
+        for i, want := range codes {
+                o := &otpParams{kind: "hotp", algo: "SHA1", digits: 6, counter: uint64(i), secret: []byte("12345678901234567890")}
+                if c, rem := o.code(time.Now()); c != want || rem != 0 {
+                        t.Errorf("counter %d: got %s %d, want %s", i, c, rem, want)
+                }
+        }
+}
+
+func TestParseOTP(t *testing.T) {
+        tests := []struct {
+                in     string
+                kind   string
+                algo   string
+                digits int
+                period int
+                ok     bool
+        }{
+                {"GEZDGNBVGY3TQOJQ", "totp", "SHA1", 6, 30, true},
+                {"gezd gnbv gy3t qojq", "totp", "SHA1", 6, 30, true},
+                {"otpauth://totp/ACME:alice?secret=GEZDGNBVGY3TQOJQ&issuer=ACME&algorithm=sha256&digits=8&period=60", "totp", "SHA256", 8, 60, true},
+                {"otpauth://hotp/bob?secret=GEZDGNBVGY3TQOJQ&counter=5", "hotp", "SHA1", 6, 30, true},
+                {"otpauth://totp/x?secret=GEZDGNBVGY3TQOJQ&digits=4", "", "", 0, 0, false},
+                {"otpauth://totp/x?secret=GEZDGNBVGY3TQOJQ&algorithm=MD5", "", "", 0, 0, false},
+                {"otpauth://push/x?secret=GEZDGNBVGY3TQOJQ", "", "", 0, 0, false},
+                {"not base32!", "", "", 0, 0, false},
+                {"", "", "", 0, 0, false},
+        }
+        for _, tc := range tests {
+                o, err := parseOTP(tc.in)
+                if (err == nil) != tc.ok {
+                        t.Errorf("%s: error %v", tc.in, err)
+                        continue
+                }
+                if !tc.ok {
+                        continue
+                }
+                if o.kind != tc.kind || o.algo != tc.algo || o.digits != tc.digits || o.period != tc.period {
+                        t.Errorf("%s: got %s %s %d %d", tc.in, o.kind, o.algo, o.digits, o.period)
+                }
+                if string(o.secret) != "1234567890" {
+                        t.Errorf("%s: secret %q", tc.in, o.secret)
+                }
+        }
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+        "os"
+        "fmt"
+        "bufio"
+        "errors"
+        "strings"
+        "encoding/json"
+)
+
+//Exit codes of command line commands, scripts can rely on them
+const (
+        exitOK        = 0
+        exitError     = 1 //any other error
+        exitUsage     = 2 //invalid command line
+        exitNotFound  = 3 //record or field doesn't exist
+        exitWrongPass = 4 //wrong pass phrase or damaged database file
+        exitLocked    = 5 //agent isn't running or locked and there is no database to unlock
+)
+
+//exitCodesHelp is shown by help of the shell and usage of command line
+const exitCodesHelp = `Exit codes of command line commands:
+	0	success
+	1	any other error
+	2	invalid command line
+	3	record or field doesn't exist
+	4	wrong pass phrase or damaged database file
+	5	agent isn't running or locked and there is no database to unlock
+`
+
+//codeError carries exit code along with the message,
+//agent sends the code to its clients too
+type codeError struct {
+        code int
+        msg  string
+}
+
+func (e *codeError) Error() string {
+        return e.msg
+}
+
+func notFoundf(format string, a ...interface{}) error {
+        return &codeError{exitNotFound, fmt.Sprintf(format, a...)}
+}
+
+func wrongPassf(format string, a ...interface{}) error {
+        return &codeError{exitWrongPass, fmt.Sprintf(format, a...)}
+}
+
+func lockedf(format string, a ...interface{}) error {
+        return &codeError{exitLocked, fmt.Sprintf(format, a...)}
+}
+
+func exitCode(err error) int {
+        var ce *codeError
+        if err == nil {
+                return exitOK
+        }
+        if errors.As(err, &ce) {
+                return ce.code
+        }
+        return exitError
+}
+
+//cliError reports error of command line command and returns its exit code
+func cliError(err error) int {
+        fmt.Fprintf(os.Stderr, "Error %s\n", err)
+        return exitCode(err)
+}
+
+//Read commands print text for people or JSON for scripts, JSON never
+//has passwords and secret values unless they are asked for explicitly
+var outputFormat = "text"
+var outputSecrets = false
+
+func jsonOutput() bool {
+        return outputFormat == "json"
+}
+
+type jsonField struct {
+        Name   string `json:"name"`
+        Value  string `json:"value,omitempty"`
+        Secret bool   `json:"secret,omitempty"`
+}
+
+type jsonRecord struct {
+        Vault    string      `json:"vault,omitempty"`
+        Id       string      `json:"id"`
+        Path     string      `json:"path"`
+        Folder   string      `json:"folder"`
+        Nick     string      `json:"nick"`
+        Login    string      `json:"login"`
+        Hint     string      `json:"hint,omitempty"`
+        Password string      `json:"password,omitempty"`
+        Urls     []string    `json:"urls,omitempty"`
+        Tags     []string    `json:"tags,omitempty"`
+        Notes    string      `json:"notes,omitempty"`
+        Fields   []jsonField `json:"fields,omitempty"`
+        Otp      bool        `json:"otp"`
+        SshKey   string      `json:"ssh_key,omitempty"` //fingerprint
+        Created  string      `json:"created,omitempty"`
+        Modified string      `json:"modified,omitempty"`
+        Changed  string      `json:"changed,omitempty"`
+}
+
+func toJSONRecord(v *Record, secrets bool) jsonRecord {
+        j := jsonRecord{
+                Id:       v.id,
+                Path:     v.path(),
+                Folder:   v.folder,
+                Nick:     v.nick,
+                Login:    v.login,
+                Hint:     v.hint,
+                Urls:     v.urls,
+                Tags:     v.tags,
+                Notes:    v.notes,
+                Otp:      v.otp != "",
+                Created:  exportTime(v.created),
+                Modified: exportTime(v.modified),
+                Changed:  exportTime(v.changed),
+        }
+        if secrets {
+                if p, err := v.secret(""); err == nil {
+                        j.Password = string(p)
+                }
+        }
+        for _, f := range v.fields {
+                jf := jsonField{Name: f.name, Value: f.value, Secret: f.secret}
+                if f.secret {
+                        jf.Value = ""
+                        if secrets {
+                                p, _ := v.fieldValue(f.name)
+                                jf.Value = string(p)
+                        }
+                }
+                j.Fields = append(j.Fields, jf)
+        }
+        if v.sshkey != "" {
+                _, j.SshKey, _ = v.sshPublic()
+        }
+        return j
+}
+
+func jsonRecords(recs []Record) []jsonRecord {
+        out := []jsonRecord{}
+        for i := range recs {
+                out = append(out, toJSONRecord(&recs[i], outputSecrets))
+        }
+        return out
+}
+
+func printJSON(v interface{}) {
+        e := json.NewEncoder(os.Stdout)
+        e.SetIndent("", "  ")
+        e.Encode(v)
+}
+
+//passFormat sets output format of read commands in the shell
+//  format json --secrets
+func passFormat(r *bufio.Reader, a *Args) {
+        f := strings.ToLower(a.opt(r, fmt.Sprintf("Output format, text or json [%s]", outputFormat)))
+        if f == "" {
+                f = outputFormat
+        }
+        if f != "text" && f != "json" {
+                fmt.Printf("Unknown format %s\n", f)
+                return
+        }
+        outputFormat = f
+        outputSecrets = false
+        if f == "json" && a.inline() {
+                outputSecrets = a.has("secrets")
+        } else if f == "json" {
+                fmt.Print("Include passwords and secret fields (y/N)> ")
+                c, _ := r.ReadString('\n')
+                outputSecrets = strings.ToLower(strings.TrimSpace(c)) == "y"
+        }
+}
+
+//cliFormat takes --json and --format text|json out of command line arguments
+func cliFormat(args []string) (string, []string, error) {
+        format := outputFormat
+        var rest []string
+        for i := 0; i < len(args); i++ {
+                a := args[i]
+                switch {
+                case a == "--json" || a == "-json":
+                        format = "json"
+                case a == "--format" || a == "-format":
+                        if i + 1 == len(args) {
+                                return "", nil, errors.New("--format needs text or json")
+                        }
+                        i++
+                        format = args[i]
+                case strings.HasPrefix(a, "--format="):
+                        format = strings.TrimPrefix(a, "--format=")
+                default:
+                        rest = append(rest, a)
+                }
+        }
+        if format != "text" && format != "json" {
+                return "", nil, fmt.Errorf("Unknown format %s", format)
+        }
+        return format, rest, nil
+}
+
+//lineFormat applies --json or --format=text|json given on shell command line
+//to this command only, returned function brings previous format back
+func lineFormat(a *Args) (func(), error) {
+        format := ""
+        if a.has("json") {
+                format = "json"
+        }
+        if a.has("format") {
+                format = a.flag("format")
+                if format != "text" && format != "json" {
+                        return nil, fmt.Errorf("Unknown format %s", format)
+                }
+        }
+        if format == "" {
+                return func() {}, nil
+        }
+        f := outputFormat
+        outputFormat = format
+        return func() {
+                outputFormat = f
+        }, nil
+}
@@ -0,0 +1,14 @@
+// +build !windows
+
+package main
+
+import (
+        "os"
+        "syscall"
+)
+
+//ownedByUs reports if file belongs to current user
+func ownedByUs(fi os.FileInfo) bool {
+        st, ok := fi.Sys().(*syscall.Stat_t)
+        return ok && int(st.Uid) == os.Getuid()
+}
@@ -5,37 +5,67 @@ import (
     "time"
     "os"
     "bufio"
+    "io"
+    "sort"
     "io/ioutil"
     "bytes"
     "strings"
-    "syscall"
+    "os/exec"
     "path/filepath"
     "crypto/sha256"
     "crypto/aes"
     "crypto/cipher"
+    "crypto/rand"
     "encoding/base64"
-    "golang.org/x/crypto/ssh/terminal"
     "github.com/artex2000/pass/clipboard"
 )
 
 type Record struct {
-    nick  string
-    login string
-    hint  string
-    pass  string //base64 encoded password 
+    id     string //random UUID, stable across edits
+    folder string //empty for root folder
+    nick   string
+    login  string
+    hint   string
+    pass   string //base64 encoded password 
+    urls   []string
+    notes  string
+    tags   []string
+    fields []Field
+    otp    string //otpauth:// URI
+    sshkey string //base64 encoded PKCS#8 PEM private key
+    created  time.Time
+    modified time.Time
+    changed  time.Time //last password change
+    history  []PassEntry //previous passwords, most recent first
+}
+
+//PassEntry is previous password of the Record
+type PassEntry struct {
+    pass     string //base64 encoded password
+    replaced time.Time
+}
+
+//Field is user-defined key/value pair attached to Record
+type Field struct {
+    name   string
+    value  string //base64 encoded if secret
+    secret bool
 }
 
 type Database struct {
         filename string
         sha      []byte
-        iv       []byte
-        key      []byte
+        key      *vaultKey
+        others   []*vaultKey //keys of files saved by other copies
         records  []Record
+        cwd      string //current folder
+        newBase  bool   //merged content becomes merge base on save
 }
 
-var db Database
+//db is active database, see vaults.go
+var db *Database
 
-type Action func(r *bufio.Reader)
+type Action func(r *bufio.Reader, a *Args)
 
 var commands = map[string]Action {
          "add":    passAdd,
@@ -46,8 +76,44 @@ var commands = map[string]Action {
          "save":   passSave,
          "paste":  passPaste,
          "help":   passHelp,
-         "delete": passTodo,
-         "find":   passTodo,
+         "delete": passDelete,
+         "find":   passFind,
+         "edit":   passEdit,
+         "show":   passShow,
+         "aging":  passAging,
+         "history": passHistory,
+         "restore": passRestore,
+         "otp":    passOtp,
+         "otp-import": passOtpImport,
+         "audit":  passAudit,
+         "policy": passPolicy,
+         "cd":     passCd,
+         "ls":     passLs,
+         "tree":   passTree,
+         "mv":     passMv,
+         "open":   passOpen,
+         "use":    passUse,
+         "vaults": passVaults,
+         "close":  passClose,
+         "copy":   passCopy,
+         "move":   passMove,
+         "merge":  passMerge,
+         "rename": passRename,
+         "sync-init": passSyncInit,
+         "sync":   passSync,
+         "import-pass": passImportStore,
+         "export-pass": passExportStore,
+         "export": passExport,
+         "render": passRenderCmd,
+         "ssh-key": passSSHKey,
+         "ssh-pub": passSSHPub,
+         "api":    passAPI,
+         "format": passFormat,
+         "approve": passApprove,
+         "deny":   passDeny,
+         "clients": passClients,
+         "tui":    passTui,
+         "config": passConfig,
 }
 
 var commands_help = map[string]string {
@@ -60,22 +126,105 @@ var commands_help = map[string]string {
          "paste":  "Paste password into clipboard",
          "help":   "List available commands",
          "delete": "Delete login/password pair",
-         "find":   "Find available login/password pairs by partial match",
+         "find":   "Find login/password pairs by partial match in all open databases",
+         "edit":   "Edit login/password pair",
+         "show":   "Show all record details",
+         "aging":  "List passwords older than given number of days",
+         "history": "Show and paste previous passwords of the record",
+         "restore": "Restore previous password of the record",
+         "otp":    "Show or paste one-time code",
+         "otp-import": "Import otpauth:// and Google Authenticator export URIs, --overwrite replaces existing secrets",
+         "audit":  "Report reused, weak, short, old and breached passwords",
+         "policy": "Set minimum password strength policy",
+         "cd":     "Change current folder",
+         "ls":     "List records and subfolders of current folder",
+         "tree":   "Show folder tree below current folder",
+         "mv":     "Move record or folder into another folder",
+         "open":   "Load password database as additional named vault",
+         "use":    "Switch active vault",
+         "vaults": "List open vaults",
+         "close":  "Close vault",
+         "copy":   "Copy record into another vault",
+         "move":   "Move record into another vault",
+         "merge":  "Merge another copy of the database using last common base",
+         "rename": "Change nickname of the record",
+         "sync-init": "Keep database in git repository and commit every save",
+         "sync":   "Pull, merge and push database with git remote",
+         "import-pass": "Import records from pass(1) store directory",
+         "export-pass": "Export records into pass(1) store directory",
+         "export": "Export records as JSON, CSV or encrypted database",
+         "render": "Render template file with {{ pass \"name\" }}, login, field and otp secrets",
+         "ssh-key": "Store SSH private key in the record",
+         "ssh-pub": "Show SSH public key of the record",
+         "api":    "Start or stop local HTTP API for browser extensions and tools",
+         "format": "Print list, info, find, show, ls, tree, vaults, aging, audit, history and otp as text or JSON",
+         "approve": "Approve pending API client",
+         "deny":   "Deny pending API client",
+         "clients": "List and revoke paired API clients",
+         "tui":    "Full screen browser of active database",
+         "config": "Show settings and where they come from",
          "quit":   "Exit program",
 }
 
+//cli are commands run from command line as "pass <command> args...",
+//without arguments pass starts interactive shell
+var cli = map[string]func(args []string) int {
+         "agent":  passAgent,
+         "get":    func(args []string) int { return agentClient("get", args) },
+         "paste":  func(args []string) int { return agentClient("paste", args) },
+         "list":   func(args []string) int { return agentClient("list", args) },
+         "status": func(args []string) int { return agentClient("status", args) },
+         "info":   func(args []string) int { return agentClient("status", args) },
+         "lock":   func(args []string) int { return agentClient("lock", args) },
+         "git-credential": passCredential,
+         "exec":   passExec,
+         "render": passRender,
+         "config": configCli,
+}
+
 func main() {
+        args, err := loadConfig(os.Args[1:])
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "Error config %s\n", err)
+                os.Exit(exitUsage)
+        }
+        if len(args) > 0 {
+                if args[0] == "help" || args[0] == "-h" {
+                        cliUsage(os.Stdout)
+                        os.Exit(exitOK)
+                }
+                c, ok := cli[args[0]]
+                if !ok {
+                        fmt.Fprintf(os.Stderr, "Unknown command: %s\n", args[0])
+                        cliUsage(os.Stderr)
+                        os.Exit(exitUsage)
+                }
+                os.Exit(c(args[1:]))
+        }
+
         //init db
-        db.filename = ""
-        db.key      = nil
-        db.iv       = nil
-        db.sha      = nil
+        db = &Database{}
+        vaults[defaultVault] = db
+        active = defaultVault
 
-        r := bufio.NewReader(os.Stdin)
+        r := bufio.NewReader(shellInput{})
+        console = newLineReader()
+        if console != nil {
+                r = console.r
+        }
         for {
-                fmt.Print("Pass> ")
-                c, _ := r.ReadString('\n')
-                c = strings.TrimSpace(c)
+                l, err := readCommand(r, fmt.Sprintf("Pass%s%s> ", promptVault(), promptFolder()))
+                l = strings.TrimSpace(l)
+                if l == "" && err != nil {
+                        //Ctrl-D, Ctrl-C or end of input
+                        fmt.Println()
+                        break
+                }
+                c, args, err := parseLine(l)
+                if err != nil {
+                        fmt.Printf("Error %s\n", err)
+                        continue
+                }
                 if c == "quit" {
                         break
                 } else if c == "" {
@@ -83,34 +232,46 @@ func main() {
                 } else {
                         if p, ok := commands[c]; !ok {
                                 fmt.Printf("Unknown command: %s\n", c)
+                        } else if restore, err := lineFormat(args); err != nil {
+                                fmt.Printf("Error %s\n", err)
                         } else {
-                                p(r)
+                                //API requests wait while command works on vaults
+                                dbMu.Lock()
+                                dbHeld = true
+                                p(r, args)
+                                dbHeld = false
+                                restore()
+                                dbMu.Unlock()
                         }
                 }
         }
 }
 
-func passTodo(r *bufio.Reader) {
+func passTodo(r *bufio.Reader, a *Args) {
         fmt.Println("Not implemented yet")
 }
 
-func passInfo(r *bufio.Reader) {
+func passInfo(r *bufio.Reader, a *Args) {
+        if jsonOutput() {
+                printJSON(vaultInfo(active))
+                return
+        }
         if db.filename == "" {
                 fmt.Println("No active database")
         }
         fmt.Printf("Database: %s, %d records\n", db.filename, len(db.records))
 }
 
-func passInit(r *bufio.Reader) {
+func passInit(r *bufio.Reader, a *Args) {
         //get filename
-        fn, err := mustPath(r, 2)
+        fn, err := mustPath(r, a, promptRetries)
         if err != nil {
                 return
         }
 
         //get pass phrase
         fmt.Print("Enter Pass phrase> ")
-        p, _ := terminal.ReadPassword(int(syscall.Stdin))
+        p, _ := readPassword()
         //Hack to clear cursor after password read
         //TODO: set correct number of white spaces for proper clearing
         fmt.Print("\rEnter Passphrase>                                      \r\n")
@@ -118,105 +279,61 @@ func passInit(r *bufio.Reader) {
                 fmt.Println("Pass phrase can't be empty")
                 return
         }
-
-        fn, err = filepath.Abs(fn)
-        if err != nil {
-                fmt.Printf("Error getting path %s\n", err)
-                return
-        }
-
-        db.filename = fn
-        db.key, db.iv = passToKey(p)
-}
-
-func passLoad(r *bufio.Reader) {
-        failed := false
-        fn, err := mustPath(r, 2)
-        if err != nil {
+        if !checkStrength(p, "Pass phrase") {
                 return
         }
 
         fn, err = filepath.Abs(fn)
         if err != nil {
-                fmt.Printf("Error opening file %s\n", err)
+                fmt.Printf("Error getting path %s\n", err)
                 return
         }
 
-        data, err := ioutil.ReadFile(fn)
+        key, err := deriveKey(p, nil)
         if err != nil {
-                fmt.Printf("Error reading file %s\n", err)
-                return
-        }
-
-        //get pass phrase
-        fmt.Print("Enter Pass phrase> ")
-        p, _ := terminal.ReadPassword(int(syscall.Stdin))
-        //Hack to clear cursor after password read
-        //TODO: set correct number of white spaces for proper clearing
-        fmt.Print("\rEnter Passphrase>                                      \r\n")
-        if len(p) == 0 {
-                fmt.Println("Pass phrase can't be empty")
+                fmt.Printf("Error deriving key %s\n", err)
                 return
         }
-
-        db.key, db.iv = passToKey(p)
         db.filename = fn
+        db.key = key
+}
 
-        defer func() {
-                if failed {
-                        db.filename = ""
-                        db.key = nil
-                        db.iv = nil
-                }
-        }()
-
-        //decrypt file
-        content, err := cryptFile(data)
+func passLoad(r *bufio.Reader, a *Args) {
+        fn, err := vaultPath(r, a)
         if err != nil {
-                failed = true
-                fmt.Printf("Error decode file: %s\n", err)
                 return
         }
 
-        //Here we will try to verify file hash
-        //First we get stored hash from file, which is encoded in base64
-        //So we ask how many base64 bytes it will take to encode 32 real bytes
-        //And read that amount from file
-        idx := base64.StdEncoding.EncodedLen(32)
-        sha_enc := content[0:idx]
-        sha := make([]byte, 32)
-        //Then we decode them from base64 to actual bytes
-        n, err := base64.StdEncoding.Decode(sha, sha_enc)
-        if err != nil || n != 32 {
-                failed = true
-                fmt.Println("Invalid file format")
-                return
+        if db.dirty() {
+                fmt.Print("Database has unsaved changes, discard them (y/N)> ")
+                c, _ := r.ReadString('\n')
+                if strings.ToLower(strings.TrimSpace(c)) != "y" {
+                        return
+                }
         }
 
-        //Now we calculate hash of records and compare it with stored hash
-        record := content[(idx + 2):]
-        sha_calc := sha256.Sum256(record)
-        if !bytes.Equal(sha, sha_calc[:]) {
-                failed = true
-                fmt.Println("File hash doesn't match")
+        p, err := readPassphrase()
+        if err != nil {
                 return
         }
-        db.sha = sha
 
-        lines := strings.Split(string(record), "\r\n")
-        for i := 0; i < len(lines); i++ {
-                t := lines[i]
-                p := strings.SplitN(t, ":", 4)
-                r := Record{p[0], p[1], p[2], p[3]} 
-                db.records = append(db.records, r)
+        d, err := loadVault(fn, p)
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
         }
+        //loaded file replaces active database content, never appends to it
+        *db = *d
 }
 
-func passSave(r *bufio.Reader) {
-        if len(db.records) == 0 {
+func passSave(r *bufio.Reader, a *Args) {
+        //new database needs records, existing file may lose its last one
+        if len(db.records) == 0 && db.sha == nil {
                 return
         }
 
+        //records of older databases get their ids on first save
+        assignIDs(db.records)
         c     := serializeDb()
         sha   := sha256.Sum256([]byte(c))
         if db.sha != nil && bytes.Equal(sha[:], db.sha) {
@@ -225,69 +342,154 @@ func passSave(r *bufio.Reader) {
         }
 
         if db.filename == "" { //no existing file
-                passInit(r)
+                passInit(r, a)
                 if db.filename == "" { //init new file failed
                         return
                 }
         }
 
-        sha_t := base64.StdEncoding.EncodeToString(sha[:])
-        out   := sha_t + "\r\n" + c
-
-        data, err := cryptFile([]byte(out))
+        old, err := saveDatabase(db, c)
         if err != nil {
-                fmt.Printf("Error encoding file %s\n", err)
+                fmt.Printf("Error %s\n", err)
                 return
         }
+        fmt.Println("Saved")
+        syncCommit(db, old)
+}
 
-        f, err := os.Create(db.filename)
+//saveDatabase writes serialized records into database file
+//and returns previous content of the file
+func saveDatabase(d *Database, c string) ([]byte, error) {
+        data, err := encodeVault(c, d.key)
         if err != nil {
-                fmt.Printf("Error open file %s\n", err)
-                return
+                return nil, fmt.Errorf("encoding file %s", err)
+        }
+
+        //keep previous version of the file
+        old, err := ioutil.ReadFile(d.filename)
+        if err == nil {
+                if err = ioutil.WriteFile(d.filename + backupExt, old, 0600); err != nil {
+                        return nil, fmt.Errorf("writing backup %s", err)
+                }
+        }
+
+        f, err := os.Create(d.filename)
+        if err != nil {
+                return nil, fmt.Errorf("open file %s", err)
         }
         defer f.Close()
         _, err = f.Write(data)
         if err != nil {
-                fmt.Printf("Error writing file %s\n", err)
-        } else {
-                fmt.Println("Saved")
+                return nil, fmt.Errorf("writing file %s", err)
         }
+
+        sha := sha256.Sum256([]byte(c))
+        d.sha = sha[:]
+        if d.newBase {
+                saveMergeBase(d, data)
+        }
+        return old, nil
 }
 
-func passHelp(r *bufio.Reader) {
+func passHelp(r *bufio.Reader, a *Args) {
         for k, v := range commands_help {
                 fmt.Printf("%s:\t%s\n", k, v)
         }
+        fmt.Println("Arguments can follow the command, missing ones are asked for:")
+        fmt.Println("\tpaste \"work/my bank\" --field=pin")
+        fmt.Println("Read commands take --json or --format=text|json for this command only")
+        fmt.Print(exitCodesHelp)
+}
+
+//cliUsage lists command line commands, pass without command starts the shell
+func cliUsage(w io.Writer) {
+        fmt.Fprintln(w, "Usage: pass [--setting=value]... [command args...]")
+        var names []string
+        for n := range cli {
+                names = append(names, n)
+        }
+        sort.Strings(names)
+        fmt.Fprintf(w, "Commands: %s\n", strings.Join(names, ", "))
+        fmt.Fprintln(w, "Without command pass starts interactive shell, its help lists shell commands")
+        fmt.Fprint(w, exitCodesHelp)
 }
 
-func passList(r *bufio.Reader) {
+func passList(r *bufio.Reader, a *Args) {
+        if jsonOutput() {
+                printJSON(jsonRecords(db.records))
+                return
+        }
         if len(db.records) == 0 {
                 fmt.Println("No records found")
                 } else {
                 for _, v := range db.records {
-                        fmt.Printf("[%s]:\tlogin: %s\t-- %s\n", v.nick, v.login, v.hint)
+                        fmt.Printf("[%s]:\tlogin: %s\t-- %s\tage: %s\n", v.path(), v.login, v.hint, v.passAge())
+                        if len(v.urls) > 0 {
+                                fmt.Printf("\turl: %s\n", strings.Join(v.urls, " "))
+                        }
+                        if len(v.tags) > 0 {
+                                fmt.Printf("\ttags: %s\n", strings.Join(v.tags, ", "))
+                        }
+                        for _, f := range v.fields {
+                                fmt.Printf("\t%s: %s\n", f.name, f.display())
+                        }
                 }
         }
 }
 
-func passAdd(r *bufio.Reader) {
-        n, err := mustString(r, "Nickname", 2, true)
+func passAdd(r *bufio.Reader, a *Args) {
+        n, err := mustString(r, a, "Nickname", promptRetries, true)
         if (err != nil) {
                 return
         }
 
-        l, err := mustString(r, "Login", 2, false)
+        l, err := mustString(r, a, "Login", promptRetries, false)
         if (err != nil) {
                 return
         }
 
-        fmt.Print("Hint (optional)> ")
-        h, _ := r.ReadString('\n')
-        h = strings.TrimSpace(h)
+        h := a.opt(r, "Hint (optional)")
+
+        p, err := mustPassword()
+        if err != nil {
+                return
+        }
+
+        f, n := resolvePath(n)
+        v := Record{id: newID(), folder: f, nick: n, login: l, hint: h, pass: base64.StdEncoding.EncodeToString(p)}
+        v.created = time.Now()
+        v.touch(true)
 
+        //record added in one line gets the rest from flags:
+        //  add work/mail alice "main box" --url=mail.example.com --tags=work
+        if a.inline() {
+                v.urls = strings.Fields(a.flag("url"))
+                v.tags = splitTags(a.flag("tags"))
+                v.notes = a.flag("notes")
+                db.records = append(db.records, v)
+                return
+        }
+
+        fmt.Print("URLs (optional, space separated)> ")
+        u, _ := r.ReadString('\n')
+        v.urls = strings.Fields(u)
+
+        fmt.Print("Tags (optional, comma separated)> ")
+        t, _ := r.ReadString('\n')
+        v.tags = splitTags(t)
+
+        v.otp, _ = readOTP(&v)
+        v.notes = readNotes(r)
+        v.fields = readFields(r, v.fields)
+
+        db.records = append(db.records, v)
+}
+
+//mustPassword reads password twice and makes sure both match
+func mustPassword() ([]byte, error) {
         for i := 0; i < 3; i++ {
                 fmt.Print("Enter Password> ")
-                p, _ := terminal.ReadPassword(int(syscall.Stdin))
+                p, _ := readPassword()
                 //Hack to clear cursor after password read
                 fmt.Print("\rEnter Password>                                      \r\n")
                 if len(p) == 0 {
@@ -296,70 +498,125 @@ func passAdd(r *bufio.Reader) {
                 }
 
                 fmt.Print("Repeat Password> ")
-                p2, _ := terminal.ReadPassword(int(syscall.Stdin))
+                p2, _ := readPassword()
                 //Hack to clear cursor after password read
                 fmt.Print("\rRepeat Password>                                     \r\n")
                 if !bytes.Equal(p, p2) {
                         fmt.Println("Passwords don't match")
-                } else {
-                        r := Record{n, l, h, base64.StdEncoding.EncodeToString(p)}
-                        db.records = append(db.records, r)
-                        break
+                } else if checkStrength(p, "Password") {
+                        return p, nil
                 }
         }
+        return nil, fmt.Errorf("Invalid password")
 }
 
-func passPaste(r *bufio.Reader) {
-        fmt.Print("Nickname> ")
-        n, _ := r.ReadString('\n')
-        n = strings.TrimSpace(n)
-        p, err := findPass(n)
+func passPaste(r *bufio.Reader, a *Args) {
+        v, err := findRecord(a.next(r, "Nickname"))
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+
+        what := "Password"
+        p, err := base64.StdEncoding.DecodeString(v.pass)
+        if err == nil && len(p) == 0 && !v.hasSecrets() {
+                err = fmt.Errorf("No password stored in %s", v.path())
+        }
+        //field is second argument or --field=name
+        f := a.flag("field")
+        if f == "" && v.hasSecrets() {
+                f = a.opt(r, "Field (empty for password)")
+        }
+        if f != "" {
+                what = f
+                p, err = v.fieldValue(f)
+        }
         if err == nil {
-                err = clipboard.WriteAll(string(p))
-                if err != nil {
-                        fmt.Println("Error pasting password into clipboard")
-                }
-                fmt.Println("Password is in clipboard")
-                st := time.Now()
-                c := time.Tick(time.Second)
-                for range c {
-                        el := int(time.Since(st).Milliseconds() / 1000)
-                        if el >= 10 {
+                pasteSecret(what, p)
+        } else {
+                fmt.Printf("Error %s\n", err)
+        }
+}
+
+//pasteSecret puts secret into clipboard and clears it after timeout
+func pasteSecret(what string, p []byte) {
+        err := writeClipboard(string(p))
+        if err != nil {
+                fmt.Println("Error pasting password into clipboard")
+        }
+        fmt.Printf("%s is in clipboard\n", what)
+        st := time.Now()
+        userInput(func() {
+                c := time.NewTicker(time.Second)
+                defer c.Stop()
+                for range c.C {
+                        left := clipboardTimeout - time.Since(st)
+                        if left <= 0 {
                                 fmt.Print("                                        \r")
                                 break
                         }
-                        fmt.Printf("Clipboard with clear in %ds\r", 10 - el)
-                }
-                err = clipboard.ClearAll()
-                if err != nil {
-                        fmt.Println("Error erasing password from clipboard")
+                        fmt.Printf("Clipboard with clear in %ds\r", int(left.Seconds() + 0.5))
                 }
-        } else {
-                fmt.Printf("Error %s\n", err)
+        })
+        err = clearClipboard()
+        if err != nil {
+                fmt.Println("Error erasing password from clipboard")
+        }
+}
+
+//writeClipboard uses configured clipboard command if there is one
+func writeClipboard(s string) error {
+        if clipboardCommand == "" {
+                return clipboard.WriteAll(s)
+        }
+        f := strings.Fields(clipboardCommand)
+        c := exec.Command(f[0], f[1:]...)
+        c.Stdin = strings.NewReader(s)
+        return c.Run()
+}
+
+func clearClipboard() error {
+        if clipboardCommand == "" {
+                return clipboard.ClearAll()
         }
+        return writeClipboard("")
 }
 
 func findPass(n string) ([]byte, error) {
-        for _, v := range db.records {
-                if v.nick == n {
-                        r, err := base64.StdEncoding.DecodeString(v.pass)
-                        if err != nil {
-                                return nil, err
-                        }
-                        return r, nil
-                }
+        v, err := findRecord(n)
+        if err != nil {
+                return nil, err
         }
-        return nil, fmt.Errorf("Record %s not found", n)
+        r, err := base64.StdEncoding.DecodeString(v.pass)
+        if err != nil {
+                return nil, err
+        }
+        return r, nil
+}
+
+//findRecord returns pointer to the record, so it can be edited in place
+//name is resolved relative to current folder, record id is accepted as well
+func findRecord(n string) (*Record, error) {
+        return db.find(n)
 }
 
-func mustString(r *bufio.Reader, prompt string, retries int, unique bool) (string, error) {
+func (d *Database) find(n string) (*Record, error) {
+        if v := d.lookup(d.resolvePath(n)); v != nil {
+                return v, nil
+        }
+        if v := d.lookupID(n); v != nil {
+                return v, nil
+        }
+        return nil, notFoundf("Record %s not found", n)
+}
+
+//mustString takes next command argument, asks for it when missing or invalid
+func mustString(r *bufio.Reader, a *Args, prompt string, retries int, unique bool) (string, error) {
         var n string
         entered := false
 
         for i := 0; i < retries; i++ {
-                fmt.Printf("%s> ", prompt)
-                n, _ = r.ReadString('\n')
-                n = strings.TrimSpace(n)
+                n = a.next(r, prompt)
                 if len(n) == 0 {
                         fmt.Printf("%s can't be empty\n", prompt)
                 } else if unique {
@@ -382,13 +639,11 @@ func mustString(r *bufio.Reader, prompt string, retries int, unique bool) (strin
         }
 }
 
-func mustPath(r *bufio.Reader, retries int) (string, error) {
+func mustPath(r *bufio.Reader, a *Args, retries int) (string, error) {
         var n string
 
         for i := 0; i < retries; i++ {
-                fmt.Print("Enter filename> ")
-                n, _ = r.ReadString('\n')
-                n = strings.TrimSpace(n)
+                n = a.next(r, "Enter filename")
                 if len(n) != 0 {
                         break
                 }
@@ -397,7 +652,7 @@ func mustPath(r *bufio.Reader, retries int) (string, error) {
         if len(n) == 0 {
                 return "", fmt.Errorf("Invalid filename")
         } else {
-                return n, nil
+                return expandHome(n), nil
         }
 }
 
@@ -410,22 +665,47 @@ func passToKey(pass []byte) (key, iv []byte) {
 }
 
 func serializeDb() string {
+        return serializeRecords(db.records)
+}
+
+func serializeRecords(records []Record) string {
         var txt []string
-        for _, v := range db.records {
-                s := fmt.Sprintf("%s:%s:%s:%s", v.nick, v.login, v.hint, v.pass)
-                txt = append(txt, s)
+        for _, v := range records {
+                txt = append(txt, encodeRecord(v))
         }
         return strings.Join(txt, "\r\n")
 }
 
-func cryptFile(data []byte) ([]byte, error) {
+//vaultMagic starts files encrypted with random IV, which follows it.
+//Older files have no header and use IV derived from pass phrase, so all
+//their versions share keystream, they are rewritten on next save
+const vaultMagic = "PASS2\n"
+
+//encodeVault prepends records hash and encrypts the result with given key,
+//every save gets new IV, versions kept by git or backups can't be xored
+func encodeVault(c string, k *vaultKey) ([]byte, error) {
+        sha   := sha256.Sum256([]byte(c))
+        sha_t := base64.StdEncoding.EncodeToString(sha[:])
+        out   := sha_t + "\r\n" + c
+        iv := make([]byte, aes.BlockSize)
+        if _, err := rand.Read(iv); err != nil {
+                return nil, err
+        }
+        data, err := cryptData([]byte(out), k.key, iv)
+        if err != nil {
+                return nil, err
+        }
+        return append(append(k.kdf.header(), iv...), data...), nil
+}
+
+func cryptData(data, key, iv []byte) ([]byte, error) {
         out := make([]byte, len(data))
-        block, err := aes.NewCipher(db.key)
+        block, err := aes.NewCipher(key)
         if err != nil {
                 return nil, err
         }
 
-        stream := cipher.NewOFB(block, db.iv)
+        stream := cipher.NewOFB(block, iv)
         stream.XORKeyStream(out, data)
         return out, nil
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+        "reflect"
+        "testing"
+        "encoding/base64"
+        "crypto/rand"
+        "crypto/ed25519"
+)
+
+//storeRoundTrip exports record into pass(1) entry and imports it back
+func storeRoundTrip(t *testing.T, v Record) Record {
+        b, err := formatStoreEntry(v)
+        if err != nil {
+                t.Fatal(err)
+        }
+        out, err := parseStoreEntry(v.path(), b)
+        if err != nil {
+                t.Fatalf("%s: %s\n%s", v.path(), err, b)
+        }
+        return out
+}
+
+func TestStoreEntry(t *testing.T) {
+        _, k, _ := ed25519.GenerateKey(rand.Reader)
+        key, err := encodeSSHKey(k)
+        if err != nil {
+                t.Fatal(err)
+        }
+        ssh := Record{id: newID(), folder: "ssh", nick: "server", sshkey: key}
+        if v := storeRoundTrip(t, ssh); v.sshkey != key || v.pass != "" || v.id != ssh.id || v.notes != "" {
+                t.Errorf("ssh key only record: %+v", v)
+        }
+
+        otp := Record{id: newID(), nick: "github", otp: "otpauth://totp/github?secret=GEZDGNBVGY3TQOJQ"}
+        if v := storeRoundTrip(t, otp); v.otp != otp.otp || v.pass != "" || v.path() != "github" {
+                t.Errorf("otp only record: %+v", v)
+        }
+
+        full := Record{id: newID(), folder: "web", nick: "mail", login: "alice", pass: "cHcx", notes: "note", sshkey: key}
+        if v := storeRoundTrip(t, full); v.sshkey != key || v.pass != "cHcx" || v.login != "alice" || v.notes != "note" {
+                t.Errorf("full record: %+v", v)
+        }
+
+        fields := Record{id: newID(), nick: "bank", pass: "cHcx", fields: []Field{
+                {"pin", base64.StdEncoding.EncodeToString([]byte("1234")), true},
+                {"branch", "main st", false},
+        }}
+        v := storeRoundTrip(t, fields)
+        if !reflect.DeepEqual(v.fields, fields.fields) {
+                t.Errorf("fields: %+v", v.fields)
+        }
+        if p, _ := v.fieldValue("pin"); string(p) != "1234" {
+                t.Errorf("secret field value %q", p)
+        }
+
+        if _, err := parseStoreEntry("empty", []byte("\n\n")); err == nil {
+                t.Error("empty entry is accepted")
+        }
+        //unfinished key stays in notes
+        if v, _ := parseStoreEntry("x", []byte("pw\n" + pemKeyBegin + "\nabc\n")); v.sshkey != "" || v.notes == "" {
+                t.Errorf("unfinished key: %+v", v)
+        }
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+        "io"
+        "os"
+        "fmt"
+        "sort"
+        "sync"
+        "bufio"
+        "strings"
+        "syscall"
+        "golang.org/x/crypto/ssh/terminal"
+)
+
+//Command line of the shell is edited with arrow keys, has history and
+//tab completion when stdin is a terminal. Raw mode is on only while command
+//is typed, commands read their input as before. History is kept in memory
+//and has command lines only, nothing typed at command prompts gets there.
+//Terminal and prompts share one reader, so answers typed ahead or pasted
+//together with command are left for prompts
+type lineReader struct {
+        sync.Mutex
+        t      *terminal.Terminal
+        r      *bufio.Reader //reader of prompts
+        fd     int
+        active bool //command is being typed
+}
+
+//shellInput is stdin of the shell, vaults are unlocked while it waits
+type shellInput struct{}
+
+func (shellInput) Read(p []byte) (n int, err error) {
+        userInput(func() { n, err = os.Stdin.Read(p) })
+        return
+}
+
+//readPassword reads pass phrase from terminal with vaults unlocked
+func readPassword() (p []byte, err error) {
+        userInput(func() { p, err = terminal.ReadPassword(int(syscall.Stdin)) })
+        return
+}
+
+//consoleInput is stdin with line ends of raw mode turned into \n
+type consoleInput struct{}
+
+func (consoleInput) Read(p []byte) (int, error) {
+        n, err := shellInput{}.Read(p)
+        for i := range p[:n] {
+                if p[i] == '\r' {
+                        p[i] = '\n'
+                }
+        }
+        return n, err
+}
+
+//termInput gives terminal one byte at a time, it keeps nothing
+//of what follows the command line in its own buffer
+type termInput struct {
+        r *bufio.Reader
+}
+
+func (t termInput) Read(p []byte) (int, error) {
+        if len(p) == 0 {
+                return 0, nil
+        }
+        b, err := t.r.ReadByte()
+        if err != nil {
+                return 0, err
+        }
+        if b == '\n' {
+                b = '\r'
+        }
+        p[0] = b
+        return 1, nil
+}
+
+//console is nil when stdin is not a terminal
+var console *lineReader
+
+//completeRecords are commands taking nickname as first argument
+var completeRecords = map[string]bool{
+        "paste":   true,
+        "delete":  true,
+        "edit":    true,
+        "show":    true,
+        "otp":     true,
+        "history": true,
+        "restore": true,
+        "rename":  true,
+        "mv":      true,
+        "copy":    true,
+        "move":    true,
+        "ssh-pub": true,
+}
+
+func newLineReader() *lineReader {
+        fd := int(syscall.Stdin)
+        if !terminal.IsTerminal(fd) || !terminal.IsTerminal(int(syscall.Stdout)) {
+                return nil
+        }
+        l := &lineReader{r: bufio.NewReader(consoleInput{}), fd: fd}
+        rw := struct {
+                io.Reader
+                io.Writer
+        }{termInput{l.r}, os.Stdout}
+        l.t = terminal.NewTerminal(rw, "")
+        l.t.AutoCompleteCallback = l.complete
+        return l
+}
+
+//readCommand shows prompt and reads command line
+func readCommand(r *bufio.Reader, prompt string) (string, error) {
+        if console == nil {
+                fmt.Print(prompt)
+                return r.ReadString('\n')
+        }
+        st, err := terminal.MakeRaw(console.fd)
+        if err != nil {
+                fmt.Print(prompt)
+                return r.ReadString('\n')
+        }
+        defer terminal.Restore(console.fd, st)
+
+        console.t.SetPrompt(prompt)
+        console.setActive(true)
+        defer console.setActive(false)
+        return console.t.ReadLine()
+}
+
+func (l *lineReader) setActive(a bool) {
+        l.Lock()
+        l.active = a
+        l.Unlock()
+}
+
+//notify prints message from background goroutine, command line
+//being typed is redrawn below it
+func notify(format string, a ...interface{}) {
+        msg := fmt.Sprintf(format, a...)
+        if console != nil {
+                console.Lock()
+                defer console.Unlock()
+                if screen != nil {
+                        screen.notice(msg)
+                        return
+                }
+                if console.active {
+                        console.t.Write([]byte(msg))
+                        return
+                }
+        }
+        fmt.Print("\n" + msg)
+}
+
+//complete is called by terminal on keys it doesn't handle itself
+func (l *lineReader) complete(line string, pos int, key rune) (string, int, bool) {
+        if key != '\t' {
+                return "", 0, false
+        }
+        head := line[:pos]
+        start, quote := argStart(head)
+        //word is argument as parseLine will see it, unfinished quote is closed
+        w := head[start:]
+        if quote != 0 {
+                w += string(quote)
+        }
+        word, _, err := parseLine(w)
+        if err != nil {
+                return "", 0, false
+        }
+        var cands []string
+        if start == 0 {
+                cands = commandNames()
+        } else {
+                c, a, err := parseLine(head[:start])
+                if err != nil || a.inline() || !completeRecords[c] {
+                        return "", 0, false
+                }
+                cands = recordNames()
+        }
+
+        var m []string
+        for _, c := range cands {
+                if strings.HasPrefix(c, word) {
+                        m = append(m, c)
+                }
+        }
+        if len(m) == 0 {
+                return "", 0, false
+        }
+        ins := quoteArg(m[0]) + " "
+        if len(m) > 1 {
+                p := commonPrefix(m)
+                if p == word {
+                        //nothing to add, show what is possible
+                        l.t.Write([]byte(strings.Join(m, "  ") + "\n"))
+                        return "", 0, false
+                }
+                //quote is left open, rest of the name is still to be typed
+                ins = quoteArg(p)
+                if ins != p {
+                        ins = ins[:len(ins) - 1]
+                }
+        }
+        return line[:start] + ins + line[pos:], start + len(ins), true
+}
+
+//argStart returns where last argument of command line starts
+//and quote it has open, spaces inside quotes don't split arguments
+func argStart(l string) (int, rune) {
+        start := 0
+        var quote rune
+        escaped := false
+        for i, c := range l {
+                switch {
+                case escaped:
+                        escaped = false
+                case c == '\\' && quote != '\'':
+                        escaped = true
+                case quote != 0:
+                        if c == quote {
+                                quote = 0
+                        }
+                case c == '"' || c == '\'':
+                        quote = c
+                case c == ' ' || c == '\t':
+                        start = i + 1
+                }
+        }
+        return start, quote
+}
+
+//quoteArg quotes name for parseLine when it has spaces or quotes
+func quoteArg(s string) string {
+        if !strings.ContainsAny(s, " \t\"'\\") {
+                return s
+        }
+        return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(s) + "\""
+}
+
+func commandNames() []string {
+        out := []string{"quit"}
+        for n := range commands {
+                out = append(out, n)
+        }
+        sort.Strings(out)
+        return out
+}
+
+//recordNames returns record names as findRecord takes them:
+//relative to current folder or absolute for records outside of it
+func recordNames() []string {
+        var out []string
+        for _, v := range db.records {
+                if db.cwd == "" {
+                        out = append(out, v.path())
+                } else if inFolder(v.folder, db.cwd) {
+                        out = append(out, strings.TrimPrefix(v.path(), db.cwd + "/"))
+                } else {
+                        out = append(out, "/" + v.path())
+                }
+        }
+        sort.Strings(out)
+        return out
+}
+
+func commonPrefix(s []string) string {
+        p := s[0]
+        for _, c := range s[1:] {
+                for !strings.HasPrefix(c, p) {
+                        p = p[:len(p) - 1]
+                }
+        }
+        return p
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+        "fmt"
+        "bufio"
+        "time"
+        "strings"
+        "crypto/rand"
+        "encoding/json"
+        "encoding/base64"
+)
+
+//storedRecord is the on-disk form of Record, one JSON object per line
+type storedRecord struct {
+        Id     string        `json:"id,omitempty"`
+        Folder string        `json:"folder,omitempty"`
+        Nick   string        `json:"nick"`
+        Login  string        `json:"login"`
+        Hint   string        `json:"hint,omitempty"`
+        Pass   string        `json:"pass"`
+        Urls   []string      `json:"urls,omitempty"`
+        Notes  string        `json:"notes,omitempty"`
+        Tags   []string      `json:"tags,omitempty"`
+        Fields []storedField `json:"fields,omitempty"`
+        Otp    string        `json:"otp,omitempty"`
+        SshKey string        `json:"sshkey,omitempty"`
+        //unix time, zero for records from older databases
+        Created  int64       `json:"created,omitempty"`
+        Modified int64       `json:"modified,omitempty"`
+        Changed  int64       `json:"changed,omitempty"`
+        History  []storedPass `json:"history,omitempty"`
+}
+
+type storedPass struct {
+        Pass     string `json:"pass"`
+        Replaced int64  `json:"replaced"`
+}
+
+type storedField struct {
+        Name   string `json:"name"`
+        Value  string `json:"value"`
+        Secret bool   `json:"secret,omitempty"`
+}
+
+const secretMask = "********"
+
+func encodeRecord(v Record) string {
+        s := storedRecord{
+                Id:    v.id,
+                Folder: v.folder,
+                Nick:  v.nick,
+                Login: v.login,
+                Hint:  v.hint,
+                Pass:  v.pass,
+                Urls:  v.urls,
+                Notes: v.notes,
+                Tags:  v.tags,
+                Otp:   v.otp,
+                SshKey: v.sshkey,
+                Created:  toUnix(v.created),
+                Modified: toUnix(v.modified),
+                Changed:  toUnix(v.changed),
+        }
+        for _, f := range v.fields {
+                s.Fields = append(s.Fields, storedField{f.name, f.value, f.secret})
+        }
+        for _, h := range v.history {
+                s.History = append(s.History, storedPass{h.pass, toUnix(h.replaced)})
+        }
+        //marshal can't fail on plain strings and slices
+        out, _ := json.Marshal(s)
+        return string(out)
+}
+
+func decodeRecord(t string) (Record, error) {
+        if !strings.HasPrefix(t, "{") {
+                return decodeLegacyRecord(t)
+        }
+
+        //legacy nickname may start with brace too, like {corp}
+        var s storedRecord
+        if err := json.Unmarshal([]byte(t), &s); err != nil {
+                if v, lerr := decodeLegacyRecord(t); lerr == nil {
+                        return v, nil
+                }
+                return Record{}, err
+        }
+        v := Record{
+                id:    s.Id,
+                folder: s.Folder,
+                nick:  s.Nick,
+                login: s.Login,
+                hint:  s.Hint,
+                pass:  s.Pass,
+                urls:  s.Urls,
+                notes: s.Notes,
+                tags:  s.Tags,
+                otp:   s.Otp,
+                sshkey: s.SshKey,
+                created:  fromUnix(s.Created),
+                modified: fromUnix(s.Modified),
+                changed:  fromUnix(s.Changed),
+        }
+        for _, f := range s.Fields {
+                v.fields = append(v.fields, Field{f.Name, f.Value, f.Secret})
+        }
+        for _, h := range s.History {
+                v.history = append(v.history, PassEntry{h.Pass, fromUnix(h.Replaced)})
+        }
+        splitNick(&v)
+        return v, nil
+}
+
+//decodeLegacyRecord parses nick:login:hint:pass format of older databases
+func decodeLegacyRecord(t string) (Record, error) {
+        //password is base64 encoded, so it never contains ':'
+        //and hint is allowed to have one
+        e := strings.LastIndex(t, ":")
+        if e < 0 {
+                return Record{}, fmt.Errorf("invalid format")
+        }
+        p := strings.SplitN(t[:e], ":", 3)
+        if len(p) != 3 {
+                return Record{}, fmt.Errorf("invalid format")
+        }
+        v := Record{nick: p[0], login: p[1], hint: p[2], pass: t[e + 1:]}
+        splitNick(&v)
+        return v, nil
+}
+
+//splitNick moves folder part of nickname like work/github into record folder,
+//records imported before folders were introduced keep it in nickname
+func splitNick(v *Record) {
+        if v.folder != "" {
+                return
+        }
+        if i := strings.LastIndex(v.nick, "/"); i >= 0 {
+                v.folder = strings.Trim(v.nick[:i], "/")
+                v.nick = v.nick[i + 1:]
+        }
+}
+
+//newID returns random version 4 UUID
+func newID() string {
+        var b [16]byte
+        if _, err := rand.Read(b[:]); err != nil {
+                panic(err)
+        }
+        b[6] = b[6] & 0x0f | 0x40
+        b[8] = b[8] & 0x3f | 0x80
+        return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func assignIDs(records []Record) {
+        for i := range records {
+                if records[i].id == "" {
+                        records[i].id = newID()
+                }
+        }
+}
+
+func toUnix(t time.Time) int64 {
+        if t.IsZero() {
+                return 0
+        }
+        return t.Unix()
+}
+
+func fromUnix(t int64) time.Time {
+        if t == 0 {
+                return time.Time{}
+        }
+        return time.Unix(t, 0)
+}
+
+//touch updates modification time, and password change time if asked
+func (v *Record) touch(passChanged bool) {
+        now := time.Now()
+        v.modified = now
+        if passChanged {
+                v.changed = now
+        }
+}
+
+//passAge returns human readable age of the password
+func (v *Record) passAge() string {
+        if v.changed.IsZero() {
+                return "unknown"
+        }
+        return fmt.Sprintf("%dd", int(time.Since(v.changed).Hours() / 24))
+}
+
+func formatTime(t time.Time) string {
+        if t.IsZero() {
+                return "unknown"
+        }
+        return t.Local().Format("2006-01-02 15:04")
+}
+
+func (f Field) display() string {
+        if f.secret {
+                return secretMask
+        }
+        return f.value
+}
+
+func (v *Record) hasSecrets() bool {
+        for _, f := range v.fields {
+                if f.secret {
+                        return true
+                }
+        }
+        return false
+}
+
+//fieldValue returns decoded value of the named field
+func (v *Record) fieldValue(name string) ([]byte, error) {
+        for _, f := range v.fields {
+                if f.name != name {
+                        continue
+                }
+                if f.secret {
+                        return base64.StdEncoding.DecodeString(f.value)
+                }
+                return []byte(f.value), nil
+        }
+        return nil, notFoundf("Field %s not found in %s", name, v.path())
+}
+
+func (v *Record) setField(f Field) {
+        for i := range v.fields {
+                if v.fields[i].name == f.name {
+                        v.fields[i] = f
+                        return
+                }
+        }
+        v.fields = append(v.fields, f)
+}
+
+func (v *Record) removeField(name string) {
+        for i := range v.fields {
+                if v.fields[i].name == name {
+                        v.fields = append(v.fields[:i], v.fields[i + 1:]...)
+                        return
+                }
+        }
+}
+
+func passShow(r *bufio.Reader, a *Args) {
+        v, err := findRecord(a.next(r, "Nickname"))
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+        if jsonOutput() {
+                printJSON(toJSONRecord(v, outputSecrets))
+                return
+        }
+
+        fmt.Printf("[%s]\n", v.path())
+        fmt.Printf("id:\t%s\n", v.id)
+        fmt.Printf("login:\t%s\n", v.login)
+        fmt.Printf("pass:\t%s\n", secretMask)
+        fmt.Printf("created:\t%s\n", formatTime(v.created))
+        fmt.Printf("modified:\t%s\n", formatTime(v.modified))
+        fmt.Printf("pass changed:\t%s (%s)\n", formatTime(v.changed), v.passAge())
+        if v.hint != "" {
+                fmt.Printf("hint:\t%s\n", v.hint)
+        }
+        for _, u := range v.urls {
+                fmt.Printf("url:\t%s\n", u)
+        }
+        if len(v.tags) > 0 {
+                fmt.Printf("tags:\t%s\n", strings.Join(v.tags, ", "))
+        }
+        if v.otp != "" {
+                fmt.Printf("otp:\t%s\n", secretMask)
+        }
+        if v.sshkey != "" {
+                if _, fp, err := v.sshPublic(); err == nil {
+                        fmt.Printf("ssh key:\t%s\n", fp)
+                } else {
+                        fmt.Printf("ssh key:\t%s\n", err)
+                }
+        }
+        for _, f := range v.fields {
+                fmt.Printf("%s:\t%s\n", f.name, f.display())
+        }
+        if v.notes != "" {
+                fmt.Println("notes:")
+                for _, l := range strings.Split(v.notes, "\n") {
+                        fmt.Printf("\t%s\n", l)
+                }
+        }
+}
+
+func passEdit(r *bufio.Reader, a *Args) {
+        orig, err := findRecord(a.next(r, "Nickname"))
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+
+        //work on a copy, so failed edit leaves record untouched
+        v := orig.clone()
+
+        //flags change given values only, nothing else is asked:
+        //  edit github --login=bob --tags=work,dev --password
+        if len(a.flags) > 0 {
+                changed := false
+                for k, val := range a.flags {
+                        switch k {
+                        case "login":
+                                v.login = val
+                        case "hint":
+                                v.hint = val
+                        case "url":
+                                v.urls = strings.Fields(val)
+                        case "tags":
+                                v.tags = splitTags(val)
+                        case "notes":
+                                v.notes = val
+                        case "password":
+                                p, err := mustPassword()
+                                if err != nil {
+                                        return
+                                }
+                                v.setPass(base64.StdEncoding.EncodeToString(p))
+                                changed = true
+                        default:
+                                fmt.Printf("Unknown flag --%s\n", k)
+                                return
+                        }
+                }
+                v.touch(changed)
+                *orig = v
+                return
+        }
+
+        fmt.Println("Empty input keeps current value, '-' clears it")
+        v.login = editString(r, "Login", v.login, false)
+        v.hint = editString(r, "Hint", v.hint, true)
+
+        changed := false
+        fmt.Print("Change password (y/N)> ")
+        c, _ := r.ReadString('\n')
+        if strings.ToLower(strings.TrimSpace(c)) == "y" {
+                p, err := mustPassword()
+                if err != nil {
+                        return
+                }
+                v.setPass(base64.StdEncoding.EncodeToString(p))
+                changed = true
+        }
+
+        v.urls = strings.Fields(editString(r, "URLs", strings.Join(v.urls, " "), true))
+        v.tags = splitTags(editString(r, "Tags", strings.Join(v.tags, ", "), true))
+
+        if v.otp != "" {
+                fmt.Print("Change OTP secret (y/N/-)> ")
+        } else {
+                fmt.Print("Add OTP secret (y/N)> ")
+        }
+        c, _ = r.ReadString('\n')
+        switch strings.ToLower(strings.TrimSpace(c)) {
+        case "y":
+                o, err := readOTP(&v)
+                if err != nil {
+                        return
+                }
+                v.otp = o
+        case "-":
+                v.otp = ""
+        }
+
+        fmt.Print("Edit notes (y/N)> ")
+        c, _ = r.ReadString('\n')
+        if strings.ToLower(strings.TrimSpace(c)) == "y" {
+                v.notes = readNotes(r)
+        }
+
+        for _, f := range v.fields {
+                fmt.Printf("\t%s: %s\n", f.name, f.display())
+        }
+        v.fields = readFields(r, v.fields)
+
+        v.touch(changed)
+        *orig = v
+}
+
+//passRename changes nickname, record keeps its id, so history
+//and merging with other copies of the database are not affected
+func passRename(r *bufio.Reader, a *Args) {
+        v, err := findRecord(a.next(r, "Nickname"))
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+
+        t := a.next(r, "New nickname")
+        if t == "" || strings.Contains(t, "/") {
+                fmt.Println("Nickname can't be empty or contain '/', use mv to change folder")
+                return
+        }
+        if db.lookup(v.folder, t) != nil {
+                fmt.Printf("%s nickname already present\n", t)
+                return
+        }
+        v.nick = t
+        v.touch(false)
+        fmt.Printf("Renamed to %s\n", v.path())
+}
+
+//passDelete removes record from active database, --yes skips confirmation
+func passDelete(r *bufio.Reader, a *Args) {
+        v, err := findRecord(a.next(r, "Nickname"))
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+        if !a.has("yes") {
+                fmt.Printf("Delete %s (y/N)> ", v.path())
+                c, _ := r.ReadString('\n')
+                if strings.ToLower(strings.TrimSpace(c)) != "y" {
+                        return
+                }
+        }
+        n := v.path()
+        removeRecord(v)
+        fmt.Printf("Deleted %s\n", n)
+}
+
+//editString asks for new value showing the current one
+func editString(r *bufio.Reader, prompt, cur string, clear bool) string {
+        fmt.Printf("%s [%s]> ", prompt, cur)
+        n, _ := r.ReadString('\n')
+        n = strings.TrimSpace(n)
+        if n == "" {
+                return cur
+        }
+        if n == "-" && clear {
+                return ""
+        }
+        return n
+}
+
+func splitTags(t string) []string {
+        var out []string
+        for _, v := range strings.Split(t, ",") {
+                v = strings.TrimSpace(v)
+                if v != "" {
+                        out = append(out, v)
+                }
+        }
+        return out
+}
+
+//readNotes reads multiline notes until empty line
+func readNotes(r *bufio.Reader) string {
+        var lines []string
+        fmt.Println("Notes (optional, finish with empty line)")
+        for {
+                fmt.Print("> ")
+                l, err := r.ReadString('\n')
+                l = strings.TrimRight(l, "\r\n")
+                if l == "" {
+                        break
+                }
+                lines = append(lines, l)
+                if err != nil {
+                        break
+                }
+        }
+        return strings.Join(lines, "\n")
+}
+
+//readFields asks for custom fields until empty name is entered
+//entering existing name replaces its value, empty value removes the field
+func readFields(r *bufio.Reader, fields []Field) []Field {
+        v := Record{fields: fields}
+        for {
+                fmt.Print("Field name (empty to finish)> ")
+                n, _ := r.ReadString('\n')
+                n = strings.TrimSpace(n)
+                if n == "" {
+                        break
+                }
+
+                fmt.Print("Secret (y/N)> ")
+                s, _ := r.ReadString('\n')
+                secret := strings.ToLower(strings.TrimSpace(s)) == "y"
+
+                var val string
+                if secret {
+                        fmt.Print("Value (empty to remove)> ")
+                        p, _ := readPassword()
+                        //Hack to clear cursor after password read
+                        fmt.Print("\rValue (empty to remove)>                                      \r\n")
+                        if len(p) > 0 {
+                                val = base64.StdEncoding.EncodeToString(p)
+                        }
+                } else {
+                        fmt.Print("Value (empty to remove)> ")
+                        val, _ = r.ReadString('\n')
+                        val = strings.TrimSpace(val)
+                }
+
+                if val == "" {
+                        v.removeField(n)
+                } else {
+                        v.setField(Field{n, val, secret})
+                }
+        }
+        return v.fields
+}
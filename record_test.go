@@ -0,0 +1,39 @@
+package main
+
+import (
+        "testing"
+)
+
+func TestDecodeRecord(t *testing.T) {
+        tests := []struct {
+                line  string
+                nick  string
+                login string
+                hint  string
+                ok    bool
+        }{
+                {"github:alice:my hint:cHcx", "github", "alice", "my hint", true},
+                {"mail:bob:a: b:cHcy", "mail", "bob", "a: b", true},
+                //legacy record which looks like JSON
+                {"{corp}:carol::cHcz", "{corp}", "carol", "", true},
+                {`{"nick":"web","login":"dave","pass":"cHc0"}`, "web", "dave", "", true},
+                {"{broken", "", "", "", false},
+                {"nopass", "", "", "", false},
+        }
+        for _, tc := range tests {
+                v, err := decodeRecord(tc.line)
+                if (err == nil) != tc.ok {
+                        t.Errorf("%s: error %v", tc.line, err)
+                        continue
+                }
+                if tc.ok && (v.nick != tc.nick || v.login != tc.login || v.hint != tc.hint) {
+                        t.Errorf("%s: got %q %q %q", tc.line, v.nick, v.login, v.hint)
+                }
+        }
+
+        v := Record{id: "1", folder: "work", nick: "{corp}", login: "eve", pass: "cHc1"}
+        d, err := decodeRecord(encodeRecord(v))
+        if err != nil || d.path() != "work/{corp}" || d.login != "eve" {
+                t.Errorf("round trip %v %v", d, err)
+        }
+}
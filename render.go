@@ -0,0 +1,126 @@
+package main
+
+import (
+        "os"
+        "fmt"
+        "flag"
+        "bufio"
+        "bytes"
+        "io/ioutil"
+        "text/template"
+        "path/filepath"
+)
+
+//Config files are rendered from Go templates with vault secrets:
+//  password: {{ pass "prod/db" }}
+//  user: {{ login "prod/db" }}
+//  key: {{ field "prod/api" "token" }}
+//  code: {{ otp "prod/vpn" }}
+//Missing record or field stops rendering, nothing is written then
+
+func renderFuncs(s secrets) template.FuncMap {
+        get := func(name, field string) (string, error) {
+                p, err := s.get(name, field)
+                return string(p), err
+        }
+        return template.FuncMap{
+                "pass":  func(name string) (string, error) { return get(name, "") },
+                "login": func(name string) (string, error) { return get(name, "login") },
+                "otp":   func(name string) (string, error) { return get(name, "otp") },
+                "field": get,
+        }
+}
+
+//renderTemplate renders template file into memory
+func renderTemplate(s secrets, fn string) ([]byte, error) {
+        data, err := ioutil.ReadFile(fn)
+        if err != nil {
+                return nil, err
+        }
+        t, err := template.New(filepath.Base(fn)).Funcs(renderFuncs(s)).Option("missingkey=error").Parse(string(data))
+        if err != nil {
+                return nil, err
+        }
+        var out bytes.Buffer
+        if err = t.Execute(&out, nil); err != nil {
+                return nil, err
+        }
+        return out.Bytes(), nil
+}
+
+//writeSecretFile replaces file atomically, result is readable by owner only
+func writeSecretFile(fn string, data []byte) error {
+        f, err := ioutil.TempFile(filepath.Dir(fn), "." + filepath.Base(fn) + ".")
+        if err != nil {
+                return err
+        }
+        //temp file is created with 0600 already
+        _, err = f.Write(data)
+        if cerr := f.Close(); err == nil {
+                err = cerr
+        }
+        if err == nil {
+                err = os.Chmod(f.Name(), 0600)
+        }
+        if err == nil {
+                err = os.Rename(f.Name(), fn)
+        }
+        if err != nil {
+                os.Remove(f.Name())
+        }
+        return err
+}
+
+//passRender is "pass render [-o file] template"
+func passRender(args []string) int {
+        fs := flag.NewFlagSet("render", flag.ContinueOnError)
+        out := fs.String("o", "", "output file, stdout if omitted")
+        if err := fs.Parse(args); err != nil {
+                return exitUsage
+        }
+        if fs.NArg() != 1 {
+                fmt.Fprintln(os.Stderr, "Usage: pass render [-o file] template")
+                return exitUsage
+        }
+
+        s, err := openSecrets()
+        if err != nil {
+                return cliError(err)
+        }
+        data, err := renderTemplate(s, fs.Arg(0))
+        if err != nil {
+                return cliError(err)
+        }
+        if *out == "" {
+                os.Stdout.Write(data)
+                return exitOK
+        }
+        if err = writeSecretFile(*out, data); err != nil {
+                return cliError(err)
+        }
+        return exitOK
+}
+
+//passRenderCmd renders template with records of active vault
+func passRenderCmd(r *bufio.Reader, a *Args) {
+        fn, err := mustPath(r, a, promptRetries)
+        if err != nil {
+                return
+        }
+        out := expandHome(a.opt(r, "Output file (empty for screen)"))
+
+        data, err := renderTemplate(&localSecrets{db}, fn)
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+        if out == "" {
+                fmt.Print(string(data))
+                return
+        }
+        if err = writeSecretFile(out, data); err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+        fmt.Printf("Written %s\n", out)
+}
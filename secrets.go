@@ -0,0 +1,156 @@
+package main
+
+import (
+        "os"
+        "fmt"
+        "time"
+        "errors"
+        "runtime"
+        "encoding/base64"
+        "golang.org/x/crypto/ssh/terminal"
+)
+
+//Command line commands get secrets from running agent, or unlock
+//database themselves when agent isn't running
+const vaultEnv = "PASS_VAULT"
+
+type secrets interface {
+        //list returns records with path or login matching pattern
+        list(pattern string) ([]agentEntry, error)
+        //get returns password, login, otp code or field value of the record
+        get(name, field string) ([]byte, error)
+        //store adds record at e.Path or changes its password
+        store(e agentEntry, pass []byte) error
+        //erase forgets password of the record if it is still pass
+        erase(name string, pass []byte) error
+}
+
+//openSecrets returns agent when it is running, database named by PASS_VAULT otherwise
+func openSecrets() (secrets, error) {
+        if _, err := agentCall(agentRequest{Cmd: "status"}); err == nil {
+                return agentSecrets{}, nil
+        }
+        fn := vaultFile
+        if fn == "" {
+                return nil, lockedf("Agent is not running and no vault is set, use %s or vault in config", vaultEnv)
+        }
+        p, err := ttyPassphrase()
+        if err != nil {
+                return nil, err
+        }
+        d, err := loadVault(fn, p)
+        if err != nil {
+                return nil, err
+        }
+        return &localSecrets{d}, nil
+}
+
+//ttyPassphrase reads pass phrase from terminal, stdin may be
+//used by the caller like git does with credential helpers
+func ttyPassphrase() ([]byte, error) {
+        name := "/dev/tty"
+        if runtime.GOOS == "windows" {
+                name = "CONIN$"
+        }
+        tty, err := os.OpenFile(name, os.O_RDWR, 0)
+        if err != nil {
+                return nil, errors.New("No terminal to read pass phrase from")
+        }
+        defer tty.Close()
+        fmt.Fprint(os.Stderr, "Enter Pass phrase> ")
+        p, err := terminal.ReadPassword(int(tty.Fd()))
+        fmt.Fprint(os.Stderr, "\r\n")
+        if err != nil {
+                return nil, err
+        }
+        if len(p) == 0 {
+                return nil, errors.New("Pass phrase can't be empty")
+        }
+        return p, nil
+}
+
+//localSecrets works on unlocked database, it is used by the agent as well
+type localSecrets struct {
+        d *Database
+}
+
+func (s *localSecrets) list(pattern string) ([]agentEntry, error) {
+        var out []agentEntry
+        for _, v := range s.d.filter(pattern) {
+                out = append(out, agentEntry{Id: v.id, Path: v.path(), Login: v.login, Urls: v.urls})
+        }
+        return out, nil
+}
+
+func (s *localSecrets) get(name, field string) ([]byte, error) {
+        v, err := s.d.find(name)
+        if err != nil {
+                return nil, err
+        }
+        return v.secret(field)
+}
+
+func (s *localSecrets) store(e agentEntry, pass []byte) error {
+        folder, nick := splitPath(e.Path)
+        if nick == "" {
+                return errors.New("Nickname can't be empty")
+        }
+        p := base64.StdEncoding.EncodeToString(pass)
+        v := s.d.lookup(folder, nick)
+        if v == nil {
+                s.d.records = append(s.d.records, Record{id: newID(), folder: folder, nick: nick,
+                                                          login: e.Login, urls: e.Urls, created: time.Now()})
+                v = &s.d.records[len(s.d.records) - 1]
+        } else if v.pass == p {
+                return nil
+        }
+        v.setPass(p)
+        v.touch(true)
+        return s.save()
+}
+
+func (s *localSecrets) erase(name string, pass []byte) error {
+        v, err := s.d.find(name)
+        if err != nil {
+                return err
+        }
+        if v.pass == "" || v.pass != base64.StdEncoding.EncodeToString(pass) {
+                return nil
+        }
+        //old password stays in history
+        v.setPass("")
+        v.touch(true)
+        return s.save()
+}
+
+func (s *localSecrets) save() error {
+        old, err := saveDatabase(s.d, serializeRecords(s.d.records))
+        if err != nil {
+                return err
+        }
+        syncCommit(s.d, old)
+        return nil
+}
+
+//agentSecrets forwards requests to running agent
+type agentSecrets struct{}
+
+func (agentSecrets) list(pattern string) ([]agentEntry, error) {
+        resp, err := agentCall(agentRequest{Cmd: "list", Name: pattern})
+        return resp.Records, err
+}
+
+func (agentSecrets) get(name, field string) ([]byte, error) {
+        resp, err := agentCall(agentRequest{Cmd: "get", Name: name, Field: field})
+        return []byte(resp.Value), err
+}
+
+func (agentSecrets) store(e agentEntry, pass []byte) error {
+        _, err := agentCall(agentRequest{Cmd: "store", Name: e.Path, Login: e.Login, Urls: e.Urls, Value: string(pass)})
+        return err
+}
+
+func (agentSecrets) erase(name string, pass []byte) error {
+        _, err := agentCall(agentRequest{Cmd: "erase", Name: name, Value: string(pass)})
+        return err
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+        "os"
+        "fmt"
+        "net"
+        "time"
+        "bufio"
+        "errors"
+        "strings"
+        "io/ioutil"
+        "crypto/x509"
+        "encoding/pem"
+        "crypto/ed25519"
+        "path/filepath"
+        "encoding/base64"
+        "golang.org/x/crypto/ssh"
+        sshagent "golang.org/x/crypto/ssh/agent"
+)
+
+//SSH private keys are kept in records unencrypted, as PKCS#8 PEM,
+//vault pass phrase protects them. Agent started with -ssh serves them
+//to ssh over ssh-agent protocol, keys never get written to disk
+const sshSockEnv = "SSH_AUTH_SOCK"
+
+//parseSSHKey reads private key file content, pass is asked
+//for keys encrypted with their own passphrase
+func parseSSHKey(data []byte, pass func() ([]byte, error)) (interface{}, error) {
+        k, err := ssh.ParseRawPrivateKey(data)
+        if _, ok := err.(*ssh.PassphraseMissingError); ok {
+                p, perr := pass()
+                if perr != nil {
+                        return nil, perr
+                }
+                k, err = ssh.ParseRawPrivateKeyWithPassphrase(data, p)
+        }
+        if err != nil {
+                return nil, err
+        }
+        //openssh format gives pointer, x509 wants value
+        if e, ok := k.(*ed25519.PrivateKey); ok {
+                k = *e
+        }
+        return k, nil
+}
+
+//encodeSSHKey converts private key into form kept in the record
+func encodeSSHKey(k interface{}) (string, error) {
+        der, err := x509.MarshalPKCS8PrivateKey(k)
+        if err != nil {
+                return "", fmt.Errorf("Unsupported key type: %s", err)
+        }
+        b := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+        return base64.StdEncoding.EncodeToString(b), nil
+}
+
+//sshKey returns private key stored in the record
+func (v *Record) sshKey() (interface{}, error) {
+        if v.sshkey == "" {
+                return nil, fmt.Errorf("No ssh key in %s", v.path())
+        }
+        b, err := base64.StdEncoding.DecodeString(v.sshkey)
+        if err != nil {
+                return nil, err
+        }
+        return ssh.ParseRawPrivateKey(b)
+}
+
+//sshPublic returns public key of the record in authorized_keys format
+//and its fingerprint
+func (v *Record) sshPublic() (string, string, error) {
+        k, err := v.sshKey()
+        if err != nil {
+                return "", "", err
+        }
+        s, err := ssh.NewSignerFromKey(k)
+        if err != nil {
+                return "", "", err
+        }
+        pub := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(s.PublicKey())))
+        return pub + " " + v.path(), ssh.FingerprintSHA256(s.PublicKey()), nil
+}
+
+//passSSHKey attaches private key from file to existing or new record
+func passSSHKey(r *bufio.Reader, a *Args) {
+        n := a.next(r, "Nickname")
+        if n == "" {
+                fmt.Println("Nickname can't be empty")
+                return
+        }
+        data, err := ioutil.ReadFile(expandHome(a.next(r, "Private key file")))
+        if err != nil {
+                fmt.Printf("Error reading file %s\n", err)
+                return
+        }
+
+        k, err := parseSSHKey(data, func() ([]byte, error) {
+                fmt.Print("Key passphrase> ")
+                p, _ := readPassword()
+                //Hack to clear cursor after password read
+                fmt.Print("\rKey passphrase>                                      \r\n")
+                return p, nil
+        })
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+        key, err := encodeSSHKey(k)
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+
+        v, err := findRecord(n)
+        if err != nil {
+                folder, nick := resolvePath(n)
+                db.records = append(db.records, Record{id: newID(), folder: folder, nick: nick, created: time.Now()})
+                v = &db.records[len(db.records) - 1]
+        } else if v.sshkey != "" {
+                fmt.Printf("Replace ssh key of %s (y/N)> ", v.path())
+                c, _ := r.ReadString('\n')
+                if strings.ToLower(strings.TrimSpace(c)) != "y" {
+                        return
+                }
+        }
+        v.sshkey = key
+        v.touch(false)
+
+        _, fp, err := v.sshPublic()
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+        fmt.Printf("Key %s stored in %s, key file can be removed now\n", fp, v.path())
+}
+
+//passSSHPub prints public key of the record, e.g. for authorized_keys
+func passSSHPub(r *bufio.Reader, a *Args) {
+        v, err := findRecord(a.next(r, "Nickname"))
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+        pub, _, err := v.sshPublic()
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+        fmt.Println(pub)
+}
+
+//sshSocket is next to agent socket
+func sshSocket() string {
+        return filepath.Join(filepath.Dir(agentSocket()), "ssh.sock")
+}
+
+//sshKeys loads keys of all records into new keyring
+func sshKeys(d *Database) sshagent.ExtendedAgent {
+        k := sshagent.NewKeyring().(sshagent.ExtendedAgent)
+        for i := range d.records {
+                v := &d.records[i]
+                if v.sshkey == "" {
+                        continue
+                }
+                key, err := v.sshKey()
+                if err != nil {
+                        continue
+                }
+                k.Add(sshagent.AddedKey{PrivateKey: key, Comment: v.path()})
+        }
+        return k
+}
+
+var errKeysReadOnly = errors.New("keys are managed by pass")
+
+//sshKeyring serves keys of the agent vault, every request
+//counts as activity, clients can't add or remove keys
+type sshKeyring struct {
+        a *agent
+}
+
+func (k sshKeyring) keys() (sshagent.ExtendedAgent, error) {
+        k.a.Lock()
+        defer k.a.Unlock()
+        if k.a.d == nil {
+                return nil, errors.New("Agent is locked")
+        }
+        k.a.timer.Reset(k.a.ttl)
+        k.a.reload()
+        return k.a.keys, nil
+}
+
+func (k sshKeyring) List() ([]*sshagent.Key, error) {
+        ks, err := k.keys()
+        if err != nil {
+                return nil, nil
+        }
+        return ks.List()
+}
+
+func (k sshKeyring) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+        return k.SignWithFlags(key, data, 0)
+}
+
+func (k sshKeyring) SignWithFlags(key ssh.PublicKey, data []byte, flags sshagent.SignatureFlags) (*ssh.Signature, error) {
+        ks, err := k.keys()
+        if err != nil {
+                return nil, err
+        }
+        return ks.SignWithFlags(key, data, flags)
+}
+
+func (k sshKeyring) Signers() ([]ssh.Signer, error) {
+        ks, err := k.keys()
+        if err != nil {
+                return nil, err
+        }
+        return ks.Signers()
+}
+
+func (k sshKeyring) Add(key sshagent.AddedKey) error { return errKeysReadOnly }
+func (k sshKeyring) Remove(key ssh.PublicKey) error { return errKeysReadOnly }
+func (k sshKeyring) RemoveAll() error { return errKeysReadOnly }
+func (k sshKeyring) Lock(p []byte) error { return errKeysReadOnly }
+func (k sshKeyring) Unlock(p []byte) error { return errKeysReadOnly }
+
+func (k sshKeyring) Extension(t string, data []byte) ([]byte, error) {
+        return nil, sshagent.ErrExtensionUnsupported
+}
+
+//serveSSH listens for ssh on its own socket
+func (a *agent) serveSSH() error {
+        sock := sshSocket()
+        os.Remove(sock)
+        ln, err := net.Listen("unix", sock)
+        if err != nil {
+                return err
+        }
+        os.Chmod(sock, 0600)
+        a.sshln = ln
+        go func() {
+                for {
+                        c, err := ln.Accept()
+                        if err != nil {
+                                return
+                        }
+                        go func() {
+                                sshagent.ServeAgent(sshKeyring{a}, c)
+                                c.Close()
+                        }()
+                }
+        }()
+        return nil
+}
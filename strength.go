@@ -0,0 +1,379 @@
+package main
+
+import (
+        "fmt"
+        "math"
+        "bufio"
+        "strconv"
+        "strings"
+        "unicode"
+)
+
+//Password strength estimation in the spirit of zxcvbn:
+//password is split into known patterns (dictionary words, keyboard walks,
+//sequences, repeats, dates), every pattern costs few bits of entropy
+//and only the rest is counted as random characters
+type strength struct {
+        entropy  float64 //bits
+        score    int     //0 (terrible) to 4 (strong)
+        warnings []string
+}
+
+var scoreNames = []string{"very weak", "weak", "fair", "good", "strong"}
+
+//minimum strength policy for new passwords and pass phrases,
+//weaker ones are rejected or accepted with a warning
+var minStrength = 2
+var rejectWeak = false
+
+//guesses per second of offline attack against fast hash
+const guessRate = 1e10
+
+//most common passwords and words used in passwords, lowercase
+var commonWords = []string{
+        "password", "passw0rd", "qwerty", "letmein", "welcome", "monkey", "dragon",
+        "master", "login", "admin", "administrator", "root", "secret", "iloveyou",
+        "princess", "sunshine", "shadow", "football", "baseball", "soccer", "hockey",
+        "superman", "batman", "trustno1", "whatever", "starwars", "computer",
+        "michael", "jennifer", "jordan", "hunter", "ranger", "buster", "thomas",
+        "robert", "charlie", "andrew", "daniel", "jessica", "pepper", "ginger",
+        "summer", "winter", "spring", "autumn", "freedom", "flower", "cookie",
+        "cheese", "coffee", "orange", "banana", "apple", "chocolate", "killer",
+        "mustang", "harley", "corvette", "ferrari", "yankees", "lakers", "cowboys",
+        "matrix", "hello", "love", "lovely", "angel", "baby", "family", "friend",
+        "google", "facebook", "twitter", "github", "linux", "windows", "android",
+        "money", "changeme", "default", "guest", "test", "pass", "access", "internet",
+        "school", "nothing", "blessed", "jesus", "london", "paris", "berlin", "moscow",
+        "tiger", "silver", "golden", "purple", "yellow", "black", "white", "green",
+}
+
+var keyboardRows = []string{
+        "1234567890-=", "qwertyuiop[]", "asdfghjkl;'", "zxcvbnm,./",
+        "1qaz2wsx3edc4rfv5tgb6yhn7ujm8ik9ol0p",
+}
+
+var leetMap = map[rune]rune{
+        '0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '@': 'a', '$': 's', '!': 'i',
+}
+
+//span is part of password matched by a pattern
+type span struct {
+        i, j    int //[i, j)
+        bits    float64
+        warning string
+}
+
+func estimateStrength(p string) strength {
+        rs := []rune(p)
+        if len(rs) == 0 {
+                return strength{warnings: []string{"Password is empty"}}
+        }
+
+        pool := charPool(rs)
+        var spans []span
+        spans = append(spans, matchWords(rs)...)
+        spans = append(spans, matchKeyboard(rs)...)
+        spans = append(spans, matchSequences(rs)...)
+        spans = append(spans, matchRepeats(rs)...)
+        spans = append(spans, matchDates(rs)...)
+
+        //dynamic programming over positions: cheapest way to cover the password
+        //either with a pattern or with random characters, like zxcvbn does
+        best := make([]float64, len(rs) + 1)
+        from := make([]int, len(rs) + 1)
+        for k := 1; k <= len(rs); k++ {
+                best[k] = best[k - 1] + math.Log2(pool)
+                from[k] = -1
+                for n, s := range spans {
+                        if s.j == k && best[s.i] + s.bits < best[k] {
+                                best[k] = best[s.i] + s.bits
+                                from[k] = n
+                        }
+                }
+        }
+
+        var st strength
+        st.entropy = best[len(rs)]
+        seen := map[string]bool{}
+        for k := len(rs); k > 0; {
+                n := from[k]
+                if n < 0 {
+                        k--
+                        continue
+                }
+                w := spans[n].warning
+                if !seen[w] {
+                        st.warnings = append(st.warnings, w)
+                        seen[w] = true
+                }
+                k = spans[n].i
+        }
+        if len(rs) < 8 {
+                st.warnings = append(st.warnings, "Password is shorter than 8 characters")
+        }
+
+        //thresholds follow crackTime: under a minute, under a month
+        //and under a thousand years of offline attack
+        switch {
+        case st.entropy < 28:
+                st.score = 0
+        case st.entropy < 40:
+                st.score = 1
+        case st.entropy < 55:
+                st.score = 2
+        case st.entropy < 70:
+                st.score = 3
+        default:
+                st.score = 4
+        }
+        return st
+}
+
+//checkStrength prints strength estimate of new password
+//and returns false if password is rejected by policy
+func checkStrength(p []byte, what string) bool {
+        st := estimateStrength(string(p))
+        fmt.Printf("%s strength: %s\n", what, st)
+        for _, w := range st.warnings {
+                fmt.Printf("\t- %s\n", w)
+        }
+        if st.score >= minStrength {
+                return true
+        }
+        if rejectWeak {
+                fmt.Printf("%s is rejected, policy requires at least %s\n", what, scoreNames[minStrength])
+                return false
+        }
+        fmt.Printf("Warning: %s is weaker than %s\n", what, scoreNames[minStrength])
+        return true
+}
+
+//  policy 3 reject
+func passPolicy(r *bufio.Reader, a *Args) {
+        s := a.opt(r, fmt.Sprintf("Minimum strength 0-4 (%s) [%d]", strings.Join(scoreNames, ", "), minStrength))
+        if s != "" {
+                n, err := strconv.Atoi(s)
+                if err != nil || n < 0 || n >= len(scoreNames) {
+                        fmt.Printf("Invalid strength: %s\n", s)
+                        return
+                }
+                minStrength = n
+        }
+
+        def := "N"
+        if rejectWeak {
+                def = "Y"
+        }
+        s = a.opt(r, fmt.Sprintf("Reject weaker passwords, otherwise warn (y/n) [%s]", def))
+        switch strings.ToLower(s) {
+        case "y", "reject":
+                rejectWeak = true
+        case "n", "warn":
+                rejectWeak = false
+        }
+
+        mode := "warn"
+        if rejectWeak {
+                mode = "reject"
+        }
+        fmt.Printf("Policy: %s below %s\n", mode, scoreNames[minStrength])
+}
+
+func (st strength) name() string {
+        return scoreNames[st.score]
+}
+
+//crackTime estimates time to guess password with offline attack
+func (st strength) crackTime() string {
+        sec := math.Pow(2, st.entropy) / 2 / guessRate
+        switch {
+        case sec < 1:
+                return "instant"
+        case sec < 60:
+                return fmt.Sprintf("%.0f seconds", sec)
+        case sec < 3600:
+                return fmt.Sprintf("%.0f minutes", sec / 60)
+        case sec < 86400:
+                return fmt.Sprintf("%.0f hours", sec / 3600)
+        case sec < 86400 * 365:
+                return fmt.Sprintf("%.0f days", sec / 86400)
+        case sec < 86400 * 365 * 100:
+                return fmt.Sprintf("%.0f years", sec / 86400 / 365)
+        }
+        return "centuries"
+}
+
+func (st strength) String() string {
+        return fmt.Sprintf("%s, %.0f bits, cracked in %s", st.name(), st.entropy, st.crackTime())
+}
+
+func charPool(rs []rune) float64 {
+        var lower, upper, digit, other bool
+        for _, c := range rs {
+                switch {
+                case unicode.IsLower(c):
+                        lower = true
+                case unicode.IsUpper(c):
+                        upper = true
+                case unicode.IsDigit(c):
+                        digit = true
+                default:
+                        other = true
+                }
+        }
+        pool := 0.0
+        if lower {
+                pool += 26
+        }
+        if upper {
+                pool += 26
+        }
+        if digit {
+                pool += 10
+        }
+        if other {
+                pool += 33
+        }
+        return pool
+}
+
+func unleet(rs []rune) []rune {
+        out := make([]rune, len(rs))
+        for i, c := range rs {
+                c = unicode.ToLower(c)
+                if l, ok := leetMap[c]; ok {
+                        c = l
+                }
+                out[i] = c
+        }
+        return out
+}
+
+func matchWords(rs []rune) []span {
+        var out []span
+        low := string(unleet(rs))
+        for rank, w := range commonWords {
+                for off := 0; ; {
+                        i := strings.Index(low[off:], w)
+                        if i < 0 {
+                                break
+                        }
+                        i += off
+                        //low is built from runes, convert byte offsets back
+                        ri := len([]rune(low[:i]))
+                        rj := ri + len([]rune(w))
+                        //capitalization and leet substitutions add a bit each
+                        bits := math.Log2(float64(rank + 1)) + 1
+                        if string(rs[ri:rj]) != w {
+                                bits += 2
+                        }
+                        out = append(out, span{ri, rj, bits, "Contains common word or password"})
+                        off = i + 1
+                }
+        }
+        return out
+}
+
+func matchKeyboard(rs []rune) []span {
+        var out []span
+        low := []rune(strings.ToLower(string(rs)))
+        for _, row := range keyboardRows {
+                rr := []rune(row)
+                for i := 0; i < len(low); i++ {
+                        for _, dir := range []int{1, -1} {
+                                j := i + 1
+                                for j < len(low) && adjacent(rr, low[j - 1], low[j], dir) {
+                                        j++
+                                }
+                                if j - i >= 4 {
+                                        out = append(out, span{i, j, math.Log2(float64(len(rr))) + 2, "Contains keyboard pattern"})
+                                }
+                        }
+                }
+        }
+        return out
+}
+
+func adjacent(row []rune, a, b rune, dir int) bool {
+        for k, c := range row {
+                if c == a {
+                        n := k + dir
+                        return n >= 0 && n < len(row) && row[n] == b
+                }
+        }
+        return false
+}
+
+//matchSequences finds runs like abcd, 1234 or 9876
+func matchSequences(rs []rune) []span {
+        var out []span
+        for i := 0; i + 2 < len(rs); {
+                d := rs[i + 1] - rs[i]
+                j := i + 1
+                if d == 1 || d == -1 {
+                        for j < len(rs) && rs[j] - rs[j - 1] == d {
+                                j++
+                        }
+                }
+                if j - i >= 3 {
+                        out = append(out, span{i, j, math.Log2(float64(len(rs))) + 3, "Contains sequence like abc or 123"})
+                        i = j
+                } else {
+                        i++
+                }
+        }
+        return out
+}
+
+//repeats are looked for in chunks up to maxRepeatChunk characters
+//in first maxRepeatInput characters, search is cubic in their length
+const maxRepeatChunk = 16
+const maxRepeatInput = 256
+
+//matchRepeats finds repeated characters (aaaa) and repeated chunks (abcabc)
+func matchRepeats(rs []rune) []span {
+        var out []span
+        if len(rs) > maxRepeatInput {
+                rs = rs[:maxRepeatInput]
+        }
+        for i := 0; i < len(rs); i++ {
+                for l := 1; l <= (len(rs) - i) / 2 && l <= maxRepeatChunk; l++ {
+                        j := i + l
+                        for j + l <= len(rs) && string(rs[j:j + l]) == string(rs[i:i + l]) {
+                                j += l
+                        }
+                        if j - i >= 2 * l && (l > 1 || j - i >= 3) {
+                                //repeats cost as much as the chunk plus count
+                                bits := float64(l) * math.Log2(charPool(rs[i:i + l])) + math.Log2(float64((j - i) / l))
+                                out = append(out, span{i, j, bits, "Contains repeated characters"})
+                        }
+                }
+        }
+        return out
+}
+
+//matchDates finds years 1900-2099 and 6-8 digit dates like 01121990
+func matchDates(rs []rune) []span {
+        var out []span
+        for i := 0; i < len(rs); i++ {
+                j := i
+                for j < len(rs) && rs[j] >= '0' && rs[j] <= '9' {
+                        j++
+                }
+                if j == i {
+                        continue
+                }
+                d := string(rs[i:j])
+                for k := 0; k + 4 <= len(d); k++ {
+                        y := d[k:k + 4]
+                        if y >= "1900" && y <= "2099" {
+                                out = append(out, span{i + k, i + k + 4, math.Log2(200), "Contains year"})
+                        }
+                }
+                if len(d) >= 6 && len(d) <= 8 {
+                        out = append(out, span{i, j, math.Log2(365 * 200), "Contains date"})
+                }
+                i = j
+        }
+        return out
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+        "os"
+        "fmt"
+        "bufio"
+        "bytes"
+        "strings"
+        "os/exec"
+        "io/ioutil"
+        "path/filepath"
+)
+
+//Database file can be kept in a git repository: every save is committed
+//and sync pulls and pushes it. Since the file is encrypted, diverged
+//copies are merged record by record (see merge.go) instead of by git
+const syncRemote = "origin"
+
+//git runs git in directory of active database file and returns trimmed output
+func git(args ...string) (string, error) {
+        return db.git(args...)
+}
+
+func (d *Database) git(args ...string) (string, error) {
+        c := exec.Command("git", args...)
+        c.Dir = filepath.Dir(d.filename)
+        c.Env = gitEnv(c.Dir)
+        var stderr bytes.Buffer
+        c.Stderr = &stderr
+        out, err := c.Output()
+        if err != nil {
+                msg := strings.TrimSpace(stderr.String())
+                if msg == "" {
+                        msg = err.Error()
+                }
+                return "", fmt.Errorf("git %s: %s", args[0], msg)
+        }
+        return strings.TrimSpace(string(out)), nil
+}
+
+//gitEnv provides commit identity when git has none configured
+func gitEnv(dir string) []string {
+        env := os.Environ()
+        c := exec.Command("git", "config", "user.email")
+        c.Dir = dir
+        if out, err := c.Output(); err != nil || len(bytes.TrimSpace(out)) == 0 {
+                env = append(env, "GIT_AUTHOR_NAME=pass", "GIT_AUTHOR_EMAIL=pass@localhost",
+                                  "GIT_COMMITTER_NAME=pass", "GIT_COMMITTER_EMAIL=pass@localhost")
+        }
+        return env
+}
+
+//syncEnabled reports if database file is tracked by git
+func (d *Database) syncEnabled() bool {
+        if d.filename == "" {
+                return false
+        }
+        if _, err := exec.LookPath("git"); err != nil {
+                return false
+        }
+        _, err := d.git("ls-files", "--error-unmatch", filepath.Base(d.filename))
+        return err == nil
+}
+
+//syncFile returns path of database file relative to repository root, as git shows it
+func syncFile() (string, error) {
+        top, err := git("rev-parse", "--show-toplevel")
+        if err != nil {
+                return "", err
+        }
+        fn, err := filepath.EvalSymlinks(db.filename)
+        if err != nil {
+                return "", err
+        }
+        top, err = filepath.EvalSymlinks(top)
+        if err != nil {
+                return "", err
+        }
+        rel, err := filepath.Rel(top, fn)
+        if err != nil {
+                return "", err
+        }
+        return filepath.ToSlash(rel), nil
+}
+
+func passSyncInit(r *bufio.Reader, a *Args) {
+        if db.filename == "" {
+                fmt.Println("Save database first")
+                return
+        }
+        if _, err := exec.LookPath("git"); err != nil {
+                fmt.Println("Error git binary not found")
+                return
+        }
+
+        if _, err := git("rev-parse", "--is-inside-work-tree"); err != nil {
+                if _, err = git("init"); err != nil {
+                        fmt.Printf("Error %s\n", err)
+                        return
+                }
+                fmt.Printf("Initialized git repository in %s\n", filepath.Dir(db.filename))
+        }
+
+        //backups and merge base are local to every copy
+        gi := filepath.Join(filepath.Dir(db.filename), ".gitignore")
+        if _, err := os.Stat(gi); os.IsNotExist(err) {
+                ioutil.WriteFile(gi, []byte("*" + backupExt + "\n*" + baseExt + "\n"), 0600)
+                git("add", ".gitignore")
+        }
+
+        u := a.opt(r, "Remote repository (optional)")
+        if u != "" {
+                _, err := git("remote", "get-url", syncRemote)
+                if err == nil {
+                        _, err = git("remote", "set-url", syncRemote, u)
+                } else {
+                        _, err = git("remote", "add", syncRemote, u)
+                }
+                if err != nil {
+                        fmt.Printf("Error %s\n", err)
+                        return
+                }
+        }
+
+        if _, err := git("add", filepath.Base(db.filename)); err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+        if _, err := git("diff", "--cached", "--quiet"); err != nil {
+                if _, err = git("commit", "-q", "-m", "Add password database"); err != nil {
+                        fmt.Printf("Error %s\n", err)
+                        return
+                }
+        }
+        fmt.Println("Sync enabled, every save is committed")
+}
+
+//syncCommit commits saved database, old is previous content of the file
+func syncCommit(d *Database, old []byte) {
+        if !d.syncEnabled() {
+                return
+        }
+        msg := "Update password database"
+        if old != nil {
+                if prev, _, err := decodeVault(old, d.keyOf(old)); err == nil {
+                        msg = changeSummary(prev, d.records)
+                }
+        }
+        if _, err := d.git("add", filepath.Base(d.filename)); err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+        if _, err := d.git("commit", "-q", "-m", msg); err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+        fmt.Printf("Committed: %s\n", msg)
+}
+
+//changeSummary describes difference between two versions of records
+//in commit message, counts only, record names would tell which accounts
+//the vault holds to everyone with access to the repository
+func changeSummary(prev, cur []Record) string {
+        var added, changed, deleted int
+        for i := range cur {
+                found := false
+                for j := range prev {
+                        if sameRecord(&cur[i], &prev[j]) {
+                                found = true
+                                if !sameContent(&cur[i], &prev[j]) || toUnix(cur[i].modified) != toUnix(prev[j].modified) {
+                                        changed++
+                                }
+                                break
+                        }
+                }
+                if !found {
+                        added++
+                }
+        }
+        for j := range prev {
+                found := false
+                for i := range cur {
+                        if sameRecord(&cur[i], &prev[j]) {
+                                found = true
+                                break
+                        }
+                }
+                if !found {
+                        deleted++
+                }
+        }
+
+        var parts []string
+        for _, p := range []struct {
+                verb  string
+                count int
+        }{{"Add", added}, {"Update", changed}, {"Delete", deleted}} {
+                switch {
+                case p.count == 1:
+                        parts = append(parts, p.verb + " 1 record")
+                case p.count > 1:
+                        parts = append(parts, fmt.Sprintf("%s %d records", p.verb, p.count))
+                }
+        }
+        if len(parts) == 0 {
+                return "Update password database"
+        }
+        return strings.Join(parts, "; ")
+}
+
+func passSync(r *bufio.Reader, a *Args) {
+        if !db.syncEnabled() {
+                fmt.Println("Sync is not enabled, use sync-init")
+                return
+        }
+        if db.dirty() {
+                fmt.Println("Database has unsaved changes, save them first")
+                return
+        }
+
+        br, err := git("rev-parse", "--abbrev-ref", "HEAD")
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+        if _, err = git("fetch", "-q", syncRemote); err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+
+        remote := syncRemote + "/" + br
+        if _, err = git("rev-parse", "--verify", "-q", remote); err != nil {
+                //nothing on remote yet
+                syncPush(br)
+                return
+        }
+
+        base, err := git("merge-base", "HEAD", remote)
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+        head, _ := git("rev-parse", "HEAD")
+        rhead, _ := git("rev-parse", remote)
+
+        switch {
+        case rhead == base:
+                //remote has nothing new
+        case head == base:
+                if _, err = git("merge", "-q", "--ff-only", remote); err != nil {
+                        fmt.Printf("Error %s\n", err)
+                        return
+                }
+                if err = reloadDatabase(); err != nil {
+                        fmt.Printf("Error %s\n", err)
+                        return
+                }
+                fmt.Printf("Updated from %s, %d records\n", remote, len(db.records))
+        default:
+                if err = syncMerge(r, base, remote); err != nil {
+                        fmt.Printf("Error %s\n", err)
+                        return
+                }
+        }
+        syncPush(br)
+}
+
+func syncPush(br string) {
+        if _, err := git("remote", "get-url", syncRemote); err != nil {
+                fmt.Println("No remote configured, nothing to push")
+                return
+        }
+        if _, err := git("push", "-q", syncRemote, "HEAD:" + br); err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+        fmt.Printf("Pushed to %s/%s\n", syncRemote, br)
+}
+
+//reloadDatabase reads database file again with current key
+func reloadDatabase() error {
+        data, err := ioutil.ReadFile(db.filename)
+        if err != nil {
+                return err
+        }
+        key, err := db.askKey(data)
+        if err != nil {
+                return err
+        }
+        records, sha, err := decodeVault(data, key)
+        if err != nil {
+                return err
+        }
+        assignIDs(records)
+        db.records, db.sha = records, sha
+        return nil
+}
+
+//syncMerge merges diverged remote version record by record and commits
+//the result as merge commit, so git history stays connected
+func syncMerge(r *bufio.Reader, base, remote string) error {
+        fn, err := syncFile()
+        if err != nil {
+                return err
+        }
+
+        other, err := gitVault(remote, fn)
+        if err != nil {
+                return fmt.Errorf("remote database: %s", err)
+        }
+        //base may not have the file yet, then every difference is a conflict
+        var prev []Record
+        if _, err := git("cat-file", "-e", base + ":" + fn); err == nil {
+                if prev, err = gitVault(base, fn); err != nil {
+                        return fmt.Errorf("base database: %s", err)
+                }
+        }
+
+        fmt.Printf("Local and %s have diverged, merging records\n", remote)
+        merged, st := mergeRecords(prev, db.records, other, promptChoice(r))
+        printMergeStats(st)
+
+        //start merge keeping our tree, then replace database file with merged one
+        if _, err = git("merge", "-q", "--no-ff", "--no-commit", "-s", "ours", remote); err != nil {
+                return err
+        }
+        local := db.records
+        db.records = merged
+        assignIDs(db.records)
+        if _, err = saveDatabase(db, serializeDb()); err != nil {
+                db.records = local
+                git("merge", "--abort")
+                return err
+        }
+        if _, err = git("add", filepath.Base(db.filename)); err != nil {
+                return err
+        }
+        msg := fmt.Sprintf("Merge %s: %d added, %d updated, %d deleted", remote, st.added, st.updated, st.deleted)
+        if _, err = git("commit", "-q", "-m", msg); err != nil {
+                return err
+        }
+        fmt.Printf("Committed: %s\n", msg)
+        return nil
+}
+
+//gitVault decodes database file stored in git revision rev
+func gitVault(rev, fn string) ([]Record, error) {
+        c := exec.Command("git", "show", rev + ":" + fn)
+        c.Dir = filepath.Dir(db.filename)
+        data, err := c.Output()
+        if err != nil {
+                return nil, fmt.Errorf("git show %s: %s", rev, err)
+        }
+        key, err := db.askKey(data)
+        if err != nil {
+                return nil, err
+        }
+        records, _, err := decodeVault(data, key)
+        return records, err
+}
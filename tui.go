@@ -0,0 +1,512 @@
+package main
+
+import (
+        "os"
+        "fmt"
+        "sync"
+        "time"
+        "bufio"
+        "strings"
+        "unicode/utf8"
+        "golang.org/x/crypto/ssh/terminal"
+)
+
+//Full screen browser of active vault:
+//  up/down, j/k select record, / filters list by path or login,
+//  l, p, o copy login, password or OTP code, e edits, d deletes,
+//  L locks, q quits
+//Secrets are never shown, clipboard is cleared after clipboardTimeout.
+//Screen locks after screenLockAfter without keys, pass phrase unlocks it
+
+type tui struct {
+        sync.Mutex
+        fd        int
+        st        *terminal.State
+        r         *bufio.Reader
+        filter    string
+        filtering bool
+        recs      []Record //filtered copy of records
+        sel, top  int
+        msg       string
+        confirm   string //record waiting for delete confirmation
+        clipUntil time.Time
+        clipTimer *time.Timer
+        lastKey   time.Time
+        locked    bool
+        pass      []byte //pass phrase typed on lock screen
+}
+
+//screen is set while full screen mode is on, notices go to its message line
+var screen *tui
+
+func passTui(r *bufio.Reader, a *Args) {
+        if console == nil {
+                fmt.Println("Full screen mode needs terminal")
+                return
+        }
+        //tui github starts with filtered list
+        t := &tui{fd: console.fd, r: r, filter: strings.Join(a.pos, " "), lastKey: time.Now()}
+        if err := t.start(); err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+        console.Lock()
+        screen = t
+        console.Unlock()
+
+        done := make(chan bool)
+        go t.tick(done)
+        t.Lock()
+        t.refresh()
+        t.draw()
+        t.Unlock()
+        t.run()
+        close(done)
+        //process may exit before timer fires, secret can't stay behind
+        if t.clipTimer != nil && t.clipTimer.Stop() {
+                clearClipboard()
+        }
+
+        console.Lock()
+        screen = nil
+        console.Unlock()
+        t.Lock()
+        t.stop()
+        t.Unlock()
+}
+
+//start switches terminal to raw mode and alternate screen
+func (t *tui) start() error {
+        st, err := terminal.MakeRaw(t.fd)
+        if err != nil {
+                return err
+        }
+        t.st = st
+        os.Stdout.WriteString("\x1b[?1049h\x1b[?25l")
+        return nil
+}
+
+func (t *tui) stop() {
+        os.Stdout.WriteString("\x1b[?25h\x1b[?1049l")
+        terminal.Restore(t.fd, t.st)
+        t.st = nil
+}
+
+//run reads keys until user quits
+func (t *tui) run() {
+        buf := make([]byte, 64)
+        for {
+                n, err := shellInput{}.Read(buf)
+                if err != nil || n == 0 {
+                        return
+                }
+                t.Lock()
+                t.lastKey = time.Now()
+                quit := false
+                for _, k := range splitKeys(string(buf[:n])) {
+                        if quit = t.key(k); quit {
+                                break
+                        }
+                }
+                if !quit {
+                        t.draw()
+                }
+                t.Unlock()
+                if quit {
+                        return
+                }
+        }
+}
+
+//splitKeys splits terminal input into keys, escape sequence is single key,
+//pasted text comes in one read and is split into characters
+func splitKeys(s string) []string {
+        if strings.HasPrefix(s, "\x1b") {
+                return []string{s}
+        }
+        var out []string
+        for _, c := range s {
+                out = append(out, string(c))
+        }
+        return out
+}
+
+//tick updates countdowns of the status bar and locks the screen
+func (t *tui) tick(done chan bool) {
+        c := time.NewTicker(time.Second)
+        defer c.Stop()
+        for {
+                select {
+                case <-done:
+                        return
+                case <-c.C:
+                }
+                t.Lock()
+                if !t.locked && db.key != nil && time.Since(t.lastKey) >= screenLockAfter {
+                        t.lock()
+                }
+                t.draw()
+                t.Unlock()
+        }
+}
+
+func (t *tui) notice(msg string) {
+        t.Lock()
+        t.msg = strings.TrimSpace(msg)
+        t.draw()
+        t.Unlock()
+}
+
+//key handles single key press, returns true when user quits
+func (t *tui) key(k string) bool {
+        if t.locked {
+                t.unlockKey(k)
+                return false
+        }
+        if t.confirm != "" {
+                if k == "y" || k == "Y" {
+                        t.delete()
+                } else {
+                        t.msg = ""
+                }
+                t.confirm = ""
+                return false
+        }
+        if t.filtering {
+                switch {
+                case k == "\r":
+                        t.filtering = false
+                case k == "\x1b":
+                        t.filtering = false
+                        t.filter = ""
+                case k == "\x7f" || k == "\b":
+                        if t.filter != "" {
+                                _, n := utf8.DecodeLastRuneInString(t.filter)
+                                t.filter = t.filter[:len(t.filter) - n]
+                        }
+                case printable(k):
+                        t.filter += k
+                }
+                t.sel, t.top = 0, 0
+                t.refresh()
+                return false
+        }
+
+        t.msg = ""
+        switch k {
+        case "q", "\x03":
+                return true
+        case "\x1b":
+                t.filter = ""
+                t.refresh()
+        case "\x1b[A", "\x1bOA", "k":
+                t.move(-1)
+        case "\x1b[B", "\x1bOB", "j":
+                t.move(1)
+        case "\x1b[5~":
+                t.move(-t.rows())
+        case "\x1b[6~":
+                t.move(t.rows())
+        case "/":
+                t.filtering = true
+        case "L":
+                if db.key == nil {
+                        t.msg = "Nothing to lock, database has no pass phrase"
+                } else {
+                        t.lock()
+                }
+        case "l", "p", "o":
+                v := t.current()
+                if v == nil {
+                        return false
+                }
+                switch k {
+                case "l":
+                        p, err := v.secret("login")
+                        if err == nil && len(p) == 0 {
+                                err = notFoundf("No login stored in %s", v.path())
+                        }
+                        t.copy("Login", p, err)
+                case "p":
+                        p, err := v.secret("")
+                        t.copy("Password", p, err)
+                case "o":
+                        c, _, err := v.otpCode()
+                        t.copy("Code", []byte(c), err)
+                }
+        case "e":
+                if v := t.current(); v != nil {
+                        t.edit(v)
+                }
+        case "d":
+                if v := t.current(); v != nil {
+                        t.confirm = v.path()
+                        t.msg = fmt.Sprintf("Delete %s (y/N)?", v.path())
+                }
+        }
+        return false
+}
+
+func printable(k string) bool {
+        return utf8.ValidString(k) && k[0] >= ' ' && !strings.ContainsAny(k, "\x1b\x7f")
+}
+
+func (t *tui) lock() {
+        t.locked = true
+        t.pass = nil
+        t.msg = ""
+}
+
+//unlockKey collects pass phrase on lock screen
+func (t *tui) unlockKey(k string) {
+        switch {
+        case k == "\r":
+                ok := db.key.matches(t.pass)
+                for i := range t.pass {
+                        t.pass[i] = 0
+                }
+                t.pass = nil
+                if !ok {
+                        t.msg = "Wrong pass phrase"
+                        return
+                }
+                t.locked = false
+                t.msg = ""
+        case k == "\x7f" || k == "\b":
+                if len(t.pass) > 0 {
+                        t.pass = t.pass[:len(t.pass) - 1]
+                }
+        case printable(k):
+                t.pass = append(t.pass, k...)
+        }
+}
+
+//refresh rebuilds list after filter or records change
+func (t *tui) refresh() {
+        t.recs = db.filter(t.filter)
+        t.move(0)
+}
+
+func (t *tui) move(d int) {
+        t.sel += d
+        if t.sel >= len(t.recs) {
+                t.sel = len(t.recs) - 1
+        }
+        if t.sel < 0 {
+                t.sel = 0
+        }
+}
+
+//current returns selected record of the database
+func (t *tui) current() *Record {
+        if t.sel >= len(t.recs) {
+                return nil
+        }
+        c := t.recs[t.sel]
+        return db.lookup(c.folder, c.nick)
+}
+
+//copy puts secret into clipboard, it is cleared in background
+func (t *tui) copy(what string, p []byte, err error) {
+        if err != nil {
+                t.msg = fmt.Sprintf("Error %s", err)
+                return
+        }
+        if err = writeClipboard(string(p)); err != nil {
+                t.msg = "Error pasting into clipboard"
+                return
+        }
+        t.msg = what + " is in clipboard"
+        t.clipUntil = time.Now().Add(clipboardTimeout)
+        if t.clipTimer != nil {
+                t.clipTimer.Stop()
+        }
+        t.clipTimer = time.AfterFunc(clipboardTimeout, func() {
+                clearClipboard()
+        })
+}
+
+//edit runs usual edit prompts with normal terminal
+func (t *tui) edit(v *Record) {
+        t.stop()
+        fmt.Printf("Editing %s\n", v.path())
+        passEdit(t.r, &Args{pos: []string{"/" + v.path()}, flags: map[string]string{}})
+        if err := t.start(); err != nil {
+                //nothing to draw on, leave full screen mode
+                t.msg = err.Error()
+                return
+        }
+        t.lastKey = time.Now()
+        t.refresh()
+}
+
+func (t *tui) delete() {
+        v := t.current()
+        if v == nil || v.path() != t.confirm {
+                return
+        }
+        removeRecord(v)
+        t.msg = fmt.Sprintf("Deleted %s", t.confirm)
+        t.refresh()
+}
+
+//rows is height of the list
+func (t *tui) rows() int {
+        _, h, err := terminal.GetSize(t.fd)
+        if err != nil || h < 5 {
+                return 1
+        }
+        return h - 4
+}
+
+//draw repaints whole screen: header, filter, list with details,
+//message line and status bar
+func (t *tui) draw() {
+        if t.st == nil {
+                return
+        }
+        w, h, err := terminal.GetSize(t.fd)
+        if err != nil || w < 20 || h < 5 {
+                return
+        }
+        var b strings.Builder
+        b.WriteString("\x1b[H")
+        line := func(s string) {
+                b.WriteString(fit(s, w) + "\r\n")
+        }
+
+        fn := db.filename
+        if fn == "" {
+                fn = "no file"
+        }
+        line(fmt.Sprintf("\x1b[7m%s\x1b[0m", fit(fmt.Sprintf(" pass: %s  %s  %d records", active, fn, len(db.records)), w)))
+
+        rows := h - 4
+        if t.locked {
+                line("")
+                for i := 0; i < rows; i++ {
+                        if i == rows / 2 {
+                                line(center("Locked, enter pass phrase", w))
+                        } else {
+                                line("")
+                        }
+                }
+        } else {
+                f := "Filter: " + t.filter
+                if t.filtering {
+                        f += "_"
+                }
+                line(f)
+
+                if t.sel < t.top {
+                        t.top = t.sel
+                }
+                if t.sel >= t.top + rows {
+                        t.top = t.sel - rows + 1
+                }
+                lw := w * 2 / 5
+                var details []string
+                if v := t.current(); v != nil {
+                        details = recordDetails(v)
+                }
+                for i := 0; i < rows; i++ {
+                        l := fit("", lw)
+                        if n := t.top + i; n < len(t.recs) {
+                                l = fit(" " + t.recs[n].path(), lw)
+                                if n == t.sel {
+                                        l = "\x1b[7m" + l + "\x1b[0m"
+                                }
+                        }
+                        d := ""
+                        if i < len(details) {
+                                d = details[i]
+                        }
+                        b.WriteString(l + "|" + fit(" " + d, w - lw - 1) + "\r\n")
+                }
+        }
+        m := t.msg
+        if m == "" && !t.locked && len(t.recs) == 0 {
+                m = "No records found"
+        }
+        line(m)
+
+        var st []string
+        if !t.clipUntil.IsZero() {
+                if left := time.Until(t.clipUntil); left > 0 {
+                        st = append(st, fmt.Sprintf("clipboard clears in %ds", int(left.Seconds() + 0.999)))
+                }
+        }
+        if db.key != nil && !t.locked {
+                left := screenLockAfter - time.Since(t.lastKey)
+                st = append(st, fmt.Sprintf("locks in %d:%02d", int(left.Minutes()), int(left.Seconds()) % 60))
+        }
+        right := "  " + strings.Join(st, "  ") + " "
+        keys := " / filter  l login  p pass  o otp  e edit  d delete  L lock  q quit"
+        if t.locked {
+                keys = ""
+        }
+        b.WriteString("\x1b[7m" + fit(keys, w - utf8.RuneCountInString(right)) + right + "\x1b[0m")
+        os.Stdout.WriteString(b.String())
+}
+
+//recordDetails are lines of detail pane, secrets masked
+func recordDetails(v *Record) []string {
+        out := []string{"[" + v.path() + "]", "login: " + v.login}
+        if v.pass != "" {
+                out = append(out, "pass: " + secretMask)
+        }
+        out = append(out, fmt.Sprintf("changed: %s (%s)", formatTime(v.changed), v.passAge()))
+        if v.hint != "" {
+                out = append(out, "hint: " + v.hint)
+        }
+        for _, u := range v.urls {
+                out = append(out, "url: " + u)
+        }
+        if len(v.tags) > 0 {
+                out = append(out, "tags: " + strings.Join(v.tags, ", "))
+        }
+        if v.otp != "" {
+                out = append(out, "otp: " + secretMask)
+        }
+        if v.sshkey != "" {
+                if _, fp, err := v.sshPublic(); err == nil {
+                        out = append(out, "ssh key: " + fp)
+                }
+        }
+        for _, f := range v.fields {
+                out = append(out, f.name + ": " + f.display())
+        }
+        if v.notes != "" {
+                out = append(out, "notes:")
+                for _, l := range strings.Split(v.notes, "\n") {
+                        out = append(out, "  " + l)
+                }
+        }
+        return out
+}
+
+//fit cuts or pads text to given width, text with escapes is taken as is
+func fit(s string, w int) string {
+        if strings.Contains(s, "\x1b") {
+                return s
+        }
+        s = strings.Map(func(r rune) rune {
+                if r < ' ' {
+                        return ' '
+                }
+                return r
+        }, s)
+        n := utf8.RuneCountInString(s)
+        if n > w {
+                return string([]rune(s)[:w])
+        }
+        return s + strings.Repeat(" ", w - n)
+}
+
+func center(s string, w int) string {
+        n := utf8.RuneCountInString(s)
+        if n >= w {
+                return s
+        }
+        return strings.Repeat(" ", (w - n) / 2) + s
+}
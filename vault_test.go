@@ -0,0 +1,125 @@
+package main
+
+import (
+        "bytes"
+        "encoding/base64"
+        "testing"
+        "crypto/sha256"
+)
+
+func testKey(t *testing.T, pass string, kdf *kdfParams) *vaultKey {
+        kdfCost = kdfMinCost
+        k, err := deriveKey([]byte(pass), kdf)
+        if err != nil {
+                t.Fatal(err)
+        }
+        return k
+}
+
+func TestVaultIV(t *testing.T) {
+        key := testKey(t, "secret phrase", nil)
+        c := serializeRecords([]Record{{id: "1", folder: "web", nick: "gh", login: "alice", pass: "cHcx"}})
+
+        a, err := encodeVault(c, key)
+        if err != nil {
+                t.Fatal(err)
+        }
+        b, err := encodeVault(c, key)
+        if err != nil {
+                t.Fatal(err)
+        }
+        if !bytes.HasPrefix(a, key.kdf.header()) {
+                t.Fatal("no header")
+        }
+        //same content saved twice has to use different keystream
+        n := len(kdfMagic) + kdfHeaderSize
+        if bytes.Equal(a[n:], b[n:]) {
+                t.Fatal("IV is reused")
+        }
+
+        for _, data := range [][]byte{a, b} {
+                recs, _, err := decodeVault(data, key)
+                if err != nil || len(recs) != 1 || recs[0].login != "alice" {
+                        t.Fatalf("decode %v %v", recs, err)
+                }
+        }
+        if _, _, err = decodeVault(a, testKey(t, "other phrase", key.kdf)); err == nil {
+                t.Fatal("wrong pass phrase accepted")
+        }
+        //other copy of the database has its own salt
+        if _, _, err = decodeVault(a, testKey(t, "secret phrase", nil)); err == nil {
+                t.Fatal("key of other salt accepted")
+        }
+        k, err := fileKey([]byte("secret phrase"), a)
+        if err != nil || !bytes.Equal(k.key, key.key) {
+                t.Fatalf("file key %v", err)
+        }
+        if !key.matches([]byte("secret phrase")) || key.matches([]byte("other phrase")) {
+                t.Fatal("matches")
+        }
+}
+
+func TestLegacyVault(t *testing.T) {
+        key := testKey(t, "secret phrase", nil)
+        c := serializeRecords([]Record{{id: "1", nick: "mail", login: "bob"}})
+        sha := sha256.Sum256([]byte(c))
+        out := []byte(base64.StdEncoding.EncodeToString(sha[:]) + "\r\n" + c)
+
+        //no header, IV comes from pass phrase
+        data, err := cryptData(out, key.old, key.iv)
+        if err != nil {
+                t.Fatal(err)
+        }
+        //PASS2 header with random IV
+        iv := bytes.Repeat([]byte{7}, len(key.iv))
+        data2, err := cryptData(out, key.old, iv)
+        if err != nil {
+                t.Fatal(err)
+        }
+        data2 = append(append([]byte(vaultMagic), iv...), data2...)
+
+        for _, d := range [][]byte{data, data2} {
+                recs, _, err := decodeVault(d, key)
+                if err != nil || len(recs) != 1 || recs[0].login != "bob" {
+                        t.Fatalf("decode %v %v", recs, err)
+                }
+        }
+}
+
+func TestKDFHeader(t *testing.T) {
+        key := testKey(t, "secret phrase", nil)
+        h := key.kdf.header()
+        k, err := fileKDF(h)
+        if err != nil || !k.equal(key.kdf) {
+                t.Fatalf("parse %v", err)
+        }
+        if k, err = fileKDF([]byte(vaultMagic)); k != nil || err != nil {
+                t.Fatal("older file has kdf")
+        }
+        h[len(h) - 3] = kdfMaxCost + 1
+        if _, err = fileKDF(h); err == nil {
+                t.Fatal("cost over limit accepted")
+        }
+        if _, err = fileKDF(h[:len(h) - 1]); err == nil {
+                t.Fatal("short header accepted")
+        }
+}
+
+func TestDirtyEmpty(t *testing.T) {
+        d := &Database{}
+        if d.dirty() {
+                t.Fatal("new empty database is dirty")
+        }
+        c := serializeRecords([]Record{{id: "1", nick: "mail", login: "bob"}})
+        sha := sha256.Sum256([]byte(c))
+        d.sha = sha[:]
+        //last record was deleted, file still has it
+        if !d.dirty() {
+                t.Fatal("emptied database is clean")
+        }
+        sha = sha256.Sum256([]byte(serializeRecords(nil)))
+        d.sha = sha[:]
+        if d.dirty() {
+                t.Fatal("saved empty database is dirty")
+        }
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+        "fmt"
+        "sort"
+        "bufio"
+        "bytes"
+        "strings"
+        "io/ioutil"
+        "path/filepath"
+        "crypto/aes"
+        "crypto/sha256"
+        "encoding/base64"
+)
+
+//Several databases can be open at once, each with its own file and pass phrase,
+//commands work on the active one (db), find searches all of them
+const defaultVault = "default"
+
+var vaults = map[string]*Database{}
+var active string
+
+//promptVault is shown in command prompt when more than one vault is open
+func promptVault() string {
+        if len(vaults) < 2 {
+                return ""
+        }
+        return "[" + active + "]"
+}
+
+//dirty reports if database has changes not written to its file,
+//emptied database is dirty when its file still has records
+func (d *Database) dirty() bool {
+        if len(d.records) == 0 && d.sha == nil {
+                return false
+        }
+        sha := sha256.Sum256([]byte(serializeRecords(d.records)))
+        return d.sha == nil || !bytes.Equal(sha[:], d.sha)
+}
+
+//lookup returns record by folder and nickname
+func (d *Database) lookup(folder, nick string) *Record {
+        for i := range d.records {
+                if d.records[i].folder == folder && d.records[i].nick == nick {
+                        return &d.records[i]
+                }
+        }
+        return nil
+}
+
+//filter returns records with path or login partially matching pattern
+//empty pattern matches all records
+func (d *Database) filter(pattern string) []Record {
+        var out []Record
+        pattern = strings.ToLower(pattern)
+        for _, v := range d.records {
+                if pattern == "" ||
+                   strings.Contains(strings.ToLower(v.path()), pattern) ||
+                   strings.Contains(strings.ToLower(v.login), pattern) {
+                        out = append(out, v)
+                }
+        }
+        return out
+}
+
+//lookupID returns record by id or its unique prefix of at least 8 characters
+func (d *Database) lookupID(id string) *Record {
+        id = strings.ToLower(id)
+        if len(id) < 8 {
+                return nil
+        }
+        var found *Record
+        for i := range d.records {
+                if strings.HasPrefix(d.records[i].id, id) {
+                        if found != nil {
+                                return nil
+                        }
+                        found = &d.records[i]
+                }
+        }
+        return found
+}
+
+func readPassphrase() ([]byte, error) {
+        fmt.Print("Enter Pass phrase> ")
+        p, _ := readPassword()
+        //Hack to clear cursor after password read
+        fmt.Print("\rEnter Passphrase>                                      \r\n")
+        if len(p) == 0 {
+                fmt.Println("Pass phrase can't be empty")
+                return nil, fmt.Errorf("Empty pass phrase")
+        }
+        return p, nil
+}
+
+//loadVault reads and decrypts database file
+func loadVault(fn string, p []byte) (*Database, error) {
+        fn, err := filepath.Abs(fn)
+        if err != nil {
+                return nil, err
+        }
+
+        data, err := ioutil.ReadFile(fn)
+        if err != nil {
+                return nil, err
+        }
+
+        key, err := fileKey(p, data)
+        if err != nil {
+                return nil, err
+        }
+        d := &Database{filename: fn, key: key}
+        d.records, d.sha, err = decodeVault(data, key)
+        if err != nil {
+                return nil, err
+        }
+        //older files get new key from fileKey already, files of other
+        //kdf.cost get it here, database shows as changed until saved with it
+        if kdf, _ := fileKDF(data); kdf == nil || int(kdf.logN) != kdfCost {
+                if kdf != nil {
+                        if d.key, err = deriveKey(p, nil); err != nil {
+                                return nil, err
+                        }
+                        d.others = []*vaultKey{key}
+                }
+                d.sha = nil
+        }
+        //records of older databases get their ids right away,
+        //so database shows as changed until saved
+        assignIDs(d.records)
+        return d, nil
+}
+
+//decodeVault decrypts database content and verifies its hash, files
+//written before kdf are decrypted with sha256 key of the same pass phrase
+func decodeVault(data []byte, k *vaultKey) ([]Record, []byte, error) {
+        key, iv := k.old, k.iv
+        kdf, err := fileKDF(data)
+        switch {
+        case err != nil:
+                return nil, nil, err
+        case kdf != nil:
+                if !kdf.equal(k.kdf) {
+                        return nil, nil, wrongPassf("File is encrypted with another key")
+                }
+                data = data[len(kdfMagic) + kdfHeaderSize:]
+                if len(data) < aes.BlockSize {
+                        return nil, nil, wrongPassf("Invalid file format")
+                }
+                key, iv, data = k.key, data[:aes.BlockSize], data[aes.BlockSize:]
+        case bytes.HasPrefix(data, []byte(vaultMagic)) && len(data) >= len(vaultMagic) + aes.BlockSize:
+                data = data[len(vaultMagic):]
+                iv, data = data[:aes.BlockSize], data[aes.BlockSize:]
+        }
+        content, err := cryptData(data, key, iv)
+        if err != nil {
+                return nil, nil, err
+        }
+
+        //Here we will try to verify file hash
+        //First we get stored hash from file, which is encoded in base64
+        //So we ask how many base64 bytes it will take to encode 32 real bytes
+        //And read that amount from file
+        idx := base64.StdEncoding.EncodedLen(32)
+        if len(content) < idx + 2 {
+                return nil, nil, wrongPassf("Invalid file format")
+        }
+        sha := make([]byte, 32)
+        //Then we decode them from base64 to actual bytes
+        n, err := base64.StdEncoding.Decode(sha, content[0:idx])
+        if err != nil || n != 32 {
+                return nil, nil, wrongPassf("Invalid file format")
+        }
+
+        //Now we calculate hash of records and compare it with stored hash
+        record := content[(idx + 2):]
+        sha_calc := sha256.Sum256(record)
+        if !bytes.Equal(sha, sha_calc[:]) {
+                return nil, nil, wrongPassf("File hash doesn't match")
+        }
+
+        var records []Record
+        if len(record) == 0 {
+                return records, sha, nil
+        }
+        lines := strings.Split(string(record), "\r\n")
+        for i := 0; i < len(lines); i++ {
+                r, err := decodeRecord(lines[i])
+                if err != nil {
+                        return nil, nil, fmt.Errorf("Invalid record %d: %s", i + 1, err)
+                }
+                records = append(records, r)
+        }
+        return records, sha, nil
+}
+
+func passOpen(r *bufio.Reader, a *Args) {
+        n, err := mustString(r, a, "Vault name", promptRetries, false)
+        if err != nil {
+                return
+        }
+        if _, ok := vaults[n]; ok {
+                fmt.Printf("Vault %s is already open\n", n)
+                return
+        }
+
+        fn, err := vaultPath(r, a)
+        if err != nil {
+                return
+        }
+        p, err := readPassphrase()
+        if err != nil {
+                return
+        }
+
+        d, err := loadVault(fn, p)
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+        vaults[n] = d
+        useVault(n)
+        fmt.Printf("Vault %s: %s, %d records\n", n, d.filename, len(d.records))
+}
+
+func useVault(n string) {
+        active = n
+        db = vaults[n]
+}
+
+func passUse(r *bufio.Reader, a *Args) {
+        n := a.next(r, "Vault name")
+        if _, ok := vaults[n]; !ok {
+                fmt.Printf("Vault %s is not open\n", n)
+                return
+        }
+        useVault(n)
+}
+
+func vaultNames() []string {
+        var names []string
+        for k := range vaults {
+                names = append(names, k)
+        }
+        sort.Strings(names)
+        return names
+}
+
+//vaultInfo is JSON form of info and vaults output
+func vaultInfo(n string) map[string]interface{} {
+        d := vaults[n]
+        return map[string]interface{}{
+                "vault":   n,
+                "active":  n == active,
+                "file":    d.filename,
+                "records": len(d.records),
+                "unsaved": d.dirty(),
+        }
+}
+
+func passVaults(r *bufio.Reader, a *Args) {
+        if jsonOutput() {
+                out := []map[string]interface{}{}
+                for _, n := range vaultNames() {
+                        out = append(out, vaultInfo(n))
+                }
+                printJSON(out)
+                return
+        }
+        for _, n := range vaultNames() {
+                d := vaults[n]
+                mark := " "
+                if n == active {
+                        mark = "*"
+                }
+                state := ""
+                if d.dirty() {
+                        state = " (unsaved)"
+                }
+                fn := d.filename
+                if fn == "" {
+                        fn = "no file"
+                }
+                fmt.Printf("%s %s:\t%s, %d records%s\n", mark, n, fn, len(d.records), state)
+        }
+}
+
+func passClose(r *bufio.Reader, a *Args) {
+        n := a.opt(r, fmt.Sprintf("Vault name [%s]", active))
+        if n == "" {
+                n = active
+        }
+        d, ok := vaults[n]
+        if !ok {
+                fmt.Printf("Vault %s is not open\n", n)
+                return
+        }
+        if d.dirty() {
+                fmt.Printf("Vault %s has unsaved changes, close anyway (y/N)> ", n)
+                c, _ := r.ReadString('\n')
+                if strings.ToLower(strings.TrimSpace(c)) != "y" {
+                        return
+                }
+        }
+
+        delete(vaults, n)
+        if len(vaults) == 0 {
+                vaults[defaultVault] = &Database{}
+        }
+        if n == active {
+                useVault(vaultNames()[0])
+        }
+}
+
+func passFind(r *bufio.Reader, a *Args) {
+        m := a.opt(r, "Pattern")
+
+        if jsonOutput() {
+                out := []jsonRecord{}
+                for _, n := range vaultNames() {
+                        for _, j := range jsonRecords(vaults[n].filter(m)) {
+                                j.Vault = n
+                                out = append(out, j)
+                        }
+                }
+                printJSON(out)
+                return
+        }
+
+        found := 0
+        for _, n := range vaultNames() {
+                for _, v := range vaults[n].filter(m) {
+                        if len(vaults) > 1 {
+                                fmt.Printf("%s:", n)
+                        }
+                        fmt.Printf("[%s]:\tlogin: %s\t-- %s\n", v.path(), v.login, v.hint)
+                        found++
+                }
+        }
+        if found == 0 {
+                fmt.Println("No records found")
+        }
+}
+
+func passCopy(r *bufio.Reader, a *Args) {
+        transferRecord(r, a, false)
+}
+
+func passMove(r *bufio.Reader, a *Args) {
+        transferRecord(r, a, true)
+}
+
+//transferRecord copies record of active vault into another vault,
+//record keeps its folder and is removed from active vault when moved
+func transferRecord(r *bufio.Reader, a *Args, move bool) {
+        if len(vaults) < 2 {
+                fmt.Println("Open another vault first")
+                return
+        }
+
+        v, err := findRecord(a.next(r, "Nickname"))
+        if err != nil {
+                fmt.Printf("Error %s\n", err)
+                return
+        }
+
+        t := a.next(r, "Destination vault")
+        d, ok := vaults[t]
+        if !ok || t == active {
+                fmt.Printf("Invalid destination vault %s\n", t)
+                return
+        }
+        if d.lookup(v.folder, v.nick) != nil {
+                fmt.Printf("%s already present in %s\n", v.path(), t)
+                return
+        }
+
+        c := v.clone()
+        //copy is another record, ids have to stay unique across vaults
+        if !move {
+                c.id = newID()
+        }
+        d.records = append(d.records, c)
+        if move {
+                removeRecord(v)
+                fmt.Printf("Moved %s to %s\n", c.path(), t)
+        } else {
+                fmt.Printf("Copied %s to %s\n", c.path(), t)
+        }
+}
+
+//clone returns deep copy of the record
+func (v *Record) clone() Record {
+        c := *v
+        c.urls = append([]string(nil), v.urls...)
+        c.tags = append([]string(nil), v.tags...)
+        c.fields = append([]Field(nil), v.fields...)
+        c.history = append([]PassEntry(nil), v.history...)
+        return c
+}
+
+//removeRecord deletes record of active vault
+func removeRecord(v *Record) {
+        for i := range db.records {
+                if &db.records[i] == v {
+                        db.records = append(db.records[:i], db.records[i + 1:]...)
+                        return
+                }
+        }
+}